@@ -32,6 +32,29 @@ type Streamer interface {
 // A HookFunc represents a middleware for packing and extracting archive.
 type HookFunc func(string, os.FileInfo) error
 
+// An Archive describes a mutable archive object which can be opened,
+// changed, extracted and saved back.
+type Archive interface {
+	Open(name string, flag int, perm os.FileMode) error
+	List(prefixes ...string) []string
+	ListMatch(m Matcher) []string
+	ListEntries(filter EntryFilter) ([]Entry, error)
+	Stat(name string) (Entry, error)
+	OpenEntry(name string) (io.ReadCloser, error)
+	ReadFile(name string) ([]byte, error)
+	View() FS
+	AddEmptyDir(dirPath string) bool
+	AddDir(dirPath, absPath string) error
+	AddFile(fileName, absPath string) error
+	DeleteIndex(idx int) error
+	DeleteName(name string) error
+	ExtractTo(destPath string, entries ...string) error
+	ExtractToFunc(destPath string, fn HookFunc, entries ...string) error
+	SetExtractOptions(opts ExtractOptions)
+	Flush() error
+	Close() error
+}
+
 // HasPrefix returns true if name has any string in given slice as prefix.
 func HasPrefix(name string, prefixes []string) bool {
 	for _, prefix := range prefixes {
@@ -108,6 +131,17 @@ func Copy(dest, src string) error {
 	return os.Chmod(dest, si.Mode())
 }
 
+// Symlink creates a symbolic link to target, it replaces any existing
+// file or symbolic link at given path.
+func Symlink(target, name string) error {
+	if fi, err := os.Lstat(name); err == nil && !fi.IsDir() {
+		if err = os.Remove(name); err != nil {
+			return err
+		}
+	}
+	return os.Symlink(target, name)
+}
+
 // Clean cleans up given path and returns a relative path that goes straight down.
 func Clean(p string) string {
 	return strings.Trim(path.Clean("/"+p), "/")
@@ -0,0 +1,71 @@
+// Copyright 2020 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package cae
+
+import (
+	"os"
+	"time"
+)
+
+// An EntryType is the type of an archive entry.
+type EntryType int
+
+const (
+	TypeFile EntryType = iota
+	TypeDir
+	TypeSymlink
+	TypeHardlink
+	TypeOther
+)
+
+func (t EntryType) String() string {
+	switch t {
+	case TypeFile:
+		return "file"
+	case TypeDir:
+		return "dir"
+	case TypeSymlink:
+		return "symlink"
+	case TypeHardlink:
+		return "hardlink"
+	}
+	return "other"
+}
+
+// An Entry describes metadata of an archive entry.
+type Entry struct {
+	Name string
+	Type EntryType
+	// Size is the uncompressed size of entry.
+	Size int64
+	// CompressedSize is -1 if unknown, e.g. entries of tar archives
+	// or entries not flushed yet.
+	CompressedSize int64
+	// Method is the compression method of entry, e.g. "deflate". It is
+	// empty if entries are not compressed individually or not flushed yet.
+	Method string
+	// CRC32 is zero if unknown.
+	CRC32   uint32
+	Mode    os.FileMode
+	ModTime time.Time
+	// Owner of entry, IDs are zero and names are empty if unknown.
+	Uid, Gid     int
+	Uname, Gname string
+	// LinkTarget is the target of symbolic or hard link.
+	LinkTarget string
+}
+
+// An EntryFilter returns true if the entry should be included.
+type EntryFilter func(e Entry) bool
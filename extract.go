@@ -0,0 +1,220 @@
+// Copyright 2020 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package cae
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ExtractOptions contains options for extracting archives.
+// The zero value is safe to use for untrusted archives.
+type ExtractOptions struct {
+	// Insecure disables checking whether entries resolve outside of
+	// the destination, it should only be used with trusted archives.
+	Insecure bool
+	// Devices allows creating character and block devices, which are
+	// skipped by default.
+	Devices bool
+
+	// SameOwner changes owner of extracted files to the one stored in
+	// archive, it usually requires root privilege.
+	SameOwner bool
+	// IDMap maps owner stored in archive before changing owner of
+	// extracted files, it implies SameOwner.
+	IDMap IDMapFunc
+
+	// Limits against decompression bombs, zero value means no limit.
+	MaxTotalSize int64   // Maximum number of total uncompressed bytes.
+	MaxEntrySize int64   // Maximum number of uncompressed bytes of a single entry.
+	MaxRatio     float64 // Maximum ratio of uncompressed bytes to compressed bytes.
+	MaxEntries   int     // Maximum number of entries.
+
+	// ExtractMatcher selects entries to be extracted if not nil,
+	// e.g. Glob("docs/**").
+	ExtractMatcher Matcher
+}
+
+// EntryMatcher returns the Matcher of entries to be extracted with given
+// options, given entries select their children as well if they are
+// directories. It returns nil if all entries should be extracted.
+func EntryMatcher(opts ExtractOptions, entries ...string) Matcher {
+	if len(entries) == 0 {
+		return opts.ExtractMatcher
+	} else if opts.ExtractMatcher == nil {
+		return Names(entries...)
+	}
+	return All(Names(entries...), opts.ExtractMatcher)
+}
+
+// Chown changes owner of extracted file to the given user and group IDs
+// if SameOwner or IDMap of options is set.
+func Chown(opts ExtractOptions, name string, uid, gid int) error {
+	if !opts.SameOwner && opts.IDMap == nil {
+		return nil
+	}
+	if opts.IDMap != nil {
+		uid, gid = opts.IDMap(uid, gid)
+	}
+	return os.Lchown(name, uid, gid)
+}
+
+// An UnsafePathError is returned when an entry resolves outside of
+// the destination.
+type UnsafePathError struct {
+	Name   string // Name of the offending entry.
+	Reason string
+}
+
+func (e *UnsafePathError) Error() string {
+	return fmt.Sprintf("cae: unsafe entry %q: %s", e.Name, e.Reason)
+}
+
+// maxLinkHops is the maximum number of symbolic links to be followed
+// when resolving a path.
+const maxLinkHops = 255
+
+var errTooManyLinks = errors.New("too many levels of symbolic links")
+
+// realPath returns the absolute path with all symbolic links evaluated,
+// components that do not exist are kept as they are.
+func realPath(p string, hops *int) (string, error) {
+	p, err := filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+
+	cur := filepath.VolumeName(p) + string(filepath.Separator)
+	parts := strings.Split(p[len(cur):], string(filepath.Separator))
+	for i, part := range parts {
+		if len(part) == 0 {
+			continue
+		}
+
+		next := filepath.Join(cur, part)
+		fi, err := os.Lstat(next)
+		if os.IsNotExist(err) {
+			return filepath.Join(append([]string{cur}, parts[i:]...)...), nil
+		} else if err != nil {
+			return "", err
+		} else if fi.Mode()&os.ModeSymlink == 0 {
+			cur = next
+			continue
+		}
+
+		if *hops--; *hops < 0 {
+			return "", errTooManyLinks
+		}
+		target, err := os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(cur, target)
+		}
+		if cur, err = realPath(target, hops); err != nil {
+			return "", err
+		}
+	}
+	return cur, nil
+}
+
+// isWithin returns true if given path is root or inside root.
+func isWithin(root, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// isAbs returns true if given slash-separated name is absolute on any platform.
+func isAbs(name string) bool {
+	return path.IsAbs(name) || (len(name) > 1 && name[1] == ':')
+}
+
+// checkWithin returns an *UnsafePathError for entry name if p does not
+// resolve inside destPath.
+func checkWithin(destPath, name, p, reason string) error {
+	hops := maxLinkHops
+	root, err := realPath(destPath, &hops)
+	if err != nil {
+		return err
+	}
+	real, err := realPath(p, &hops)
+	if err == errTooManyLinks {
+		return &UnsafePathError{Name: name, Reason: err.Error()}
+	} else if err != nil {
+		return err
+	}
+
+	if !isWithin(root, real) {
+		return &UnsafePathError{Name: name, Reason: reason}
+	}
+	return nil
+}
+
+// SecureJoin joins destination and the raw entry name, it returns an
+// *UnsafePathError if the name is absolute, escapes by "..", or goes through
+// a symbolic link which resolves outside of the destination.
+func SecureJoin(destPath, name string) (string, error) {
+	slashed := strings.ReplaceAll(name, "\\", "/")
+	if isAbs(slashed) {
+		return "", &UnsafePathError{Name: name, Reason: "absolute path"}
+	}
+	if cleaned := path.Clean(slashed); cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", &UnsafePathError{Name: name, Reason: "path escapes by parent directory"}
+	}
+
+	fullPath := filepath.Join(destPath, filepath.FromSlash(Clean(slashed)))
+	if err := checkWithin(destPath, name, fullPath, "path resolves outside through symbolic link"); err != nil {
+		return "", err
+	}
+	return fullPath, nil
+}
+
+// CheckSymlink returns an *UnsafePathError if the target of symbolic link
+// entry resolves outside of the destination. The target is relative to
+// the directory of the entry.
+func CheckSymlink(destPath, name, target string) error {
+	slashed := strings.ReplaceAll(target, "\\", "/")
+	if isAbs(slashed) {
+		return &UnsafePathError{Name: name, Reason: "absolute symbolic link target"}
+	}
+
+	dir := filepath.Dir(filepath.Join(destPath, filepath.FromSlash(Clean(name))))
+	return checkWithin(destPath, name, filepath.Join(dir, filepath.FromSlash(slashed)),
+		"symbolic link target resolves outside")
+}
+
+// CheckHardlink returns an *UnsafePathError if the target of hard link
+// entry resolves outside of the destination. The target is relative to
+// the root of archive.
+func CheckHardlink(destPath, name, target string) error {
+	slashed := strings.ReplaceAll(target, "\\", "/")
+	if isAbs(slashed) {
+		return &UnsafePathError{Name: name, Reason: "absolute hard link target"}
+	}
+	if cleaned := path.Clean(slashed); cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return &UnsafePathError{Name: name, Reason: "hard link target escapes by parent directory"}
+	}
+
+	return checkWithin(destPath, name, filepath.Join(destPath, filepath.FromSlash(Clean(slashed))),
+		"hard link target resolves outside through symbolic link")
+}
@@ -0,0 +1,130 @@
+// Copyright 2020 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package cae
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSecureJoin(t *testing.T) {
+	Convey("Join entry names with destination securely", t, func() {
+		dest := filepath.Join(os.TempDir(), "testdata/TestSecureJoin")
+		os.RemoveAll(dest)
+		So(os.MkdirAll(dest, os.ModePerm), ShouldBeNil)
+
+		Convey("Join a normal name", func() {
+			p, err := SecureJoin(dest, "dir/./file")
+			So(err, ShouldBeNil)
+			So(p, ShouldEqual, filepath.Join(dest, "dir/file"))
+		})
+
+		Convey("Join names that escape destination", func() {
+			for _, name := range []string{"/etc/passwd", "C:/Windows", "../evil", "a/../../evil", "..\\evil"} {
+				_, err := SecureJoin(dest, name)
+				So(err, ShouldHaveSameTypeAs, &UnsafePathError{})
+				So(err.(*UnsafePathError).Name, ShouldEqual, name)
+			}
+		})
+
+		Convey("Join a name goes through symbolic link", func() {
+			So(os.Symlink(os.TempDir(), filepath.Join(dest, "outside")), ShouldBeNil)
+			So(os.Symlink("outside", filepath.Join(dest, "chain")), ShouldBeNil)
+			So(os.Symlink(".", filepath.Join(dest, "inside")), ShouldBeNil)
+
+			_, err := SecureJoin(dest, "chain/evil")
+			So(err, ShouldHaveSameTypeAs, &UnsafePathError{})
+			_, err = SecureJoin(dest, "inside/file")
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Check link targets", func() {
+			So(CheckSymlink(dest, "dir/link", "../file"), ShouldBeNil)
+			So(CheckSymlink(dest, "dir/link", "../../evil"), ShouldHaveSameTypeAs, &UnsafePathError{})
+			So(CheckSymlink(dest, "link", "/etc"), ShouldHaveSameTypeAs, &UnsafePathError{})
+			So(CheckHardlink(dest, "dir/link", "file"), ShouldBeNil)
+			So(CheckHardlink(dest, "link", "../evil"), ShouldHaveSameTypeAs, &UnsafePathError{})
+		})
+	})
+}
+
+func TestLimiter(t *testing.T) {
+	Convey("Enforce limits of extraction", t, func() {
+		data := bytes.Repeat([]byte("a"), 100)
+
+		Convey("Limit number of entries", func() {
+			l := NewLimiter(ExtractOptions{MaxEntries: 1})
+			So(l.Entry("a"), ShouldBeNil)
+			So(l.Entry("b"), ShouldResemble, &LimitError{Name: "b", Limit: "MaxEntries"})
+		})
+
+		Convey("Limit size of single entry", func() {
+			l := NewLimiter(ExtractOptions{MaxEntrySize: 100})
+			n, err := io.Copy(ioutil.Discard, l.Reader("a", bytes.NewReader(data)))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 100)
+
+			r := l.Reader("b", io.MultiReader(bytes.NewReader(data), bytes.NewReader(data)))
+			n, err = io.Copy(ioutil.Discard, r)
+			So(err, ShouldResemble, &LimitError{Name: "b", Limit: "MaxEntrySize"})
+			So(n, ShouldEqual, 101)
+
+			// Reading on after the limit is exceeded stays bounded.
+			n2, err := r.Read(make([]byte, 50))
+			So(err, ShouldResemble, &LimitError{Name: "b", Limit: "MaxEntrySize"})
+			So(n2, ShouldBeLessThanOrEqualTo, 1)
+		})
+
+		Convey("Limit total size", func() {
+			l := NewLimiter(ExtractOptions{MaxTotalSize: 150})
+			_, err := io.Copy(ioutil.Discard, l.Reader("a", bytes.NewReader(data)))
+			So(err, ShouldBeNil)
+			_, err = io.Copy(ioutil.Discard, l.Reader("b", bytes.NewReader(data)))
+			So(err, ShouldResemble, &LimitError{Name: "b", Limit: "MaxTotalSize"})
+		})
+
+		Convey("Limit compression ratio", func() {
+			// open consumes all compressed content and returns data.
+			open := func(raw io.Reader) (io.ReadCloser, error) {
+				if _, err := io.Copy(ioutil.Discard, raw); err != nil {
+					return nil, err
+				}
+				return ioutil.NopCloser(bytes.NewReader(data)), nil
+			}
+
+			l := NewLimiter(ExtractOptions{MaxRatio: 10})
+			rc, err := l.EntryReader("a", bytes.NewReader(data[:10]), open)
+			So(err, ShouldBeNil)
+			_, err = io.Copy(ioutil.Discard, rc)
+			So(err, ShouldBeNil)
+			rc, err = l.EntryReader("b", bytes.NewReader(data[:5]), open)
+			So(err, ShouldBeNil)
+			_, err = io.Copy(ioutil.Discard, rc)
+			So(err, ShouldResemble, &LimitError{Name: "b", Limit: "MaxRatio"})
+
+			l = NewLimiter(ExtractOptions{MaxRatio: 10})
+			_, err = ioutil.ReadAll(l.CompressedReader(bytes.NewReader(data[:5])))
+			So(err, ShouldBeNil)
+			_, err = io.Copy(ioutil.Discard, l.Reader("c", bytes.NewReader(data)))
+			So(err, ShouldResemble, &LimitError{Name: "c", Limit: "MaxRatio"})
+		})
+	})
+}
@@ -0,0 +1,161 @@
+// Copyright 2020 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package cae
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// ErrFormat indicates that the archive format is unknown.
+var ErrFormat = errors.New("cae: unknown archive format")
+
+// A Format describes an archive format implemented by a subpackage.
+type Format struct {
+	// Name is the name of format, e.g. "zip".
+	Name string
+	// Match reports whether the header bytes belong to the format.
+	Match func(header []byte) bool
+	// Open opens the named archive file for reading.
+	Open func(name string) (Archive, error)
+}
+
+// sniffLen is the number of header bytes used to detect the format.
+const sniffLen = 512
+
+var (
+	formatsMu sync.RWMutex
+	formats   []Format
+)
+
+// RegisterFormat registers an archive format for use by Open, OpenReader and
+// ExtractTo. It is usually called in the init function of format package.
+func RegisterFormat(f Format) {
+	formatsMu.Lock()
+	formats = append(formats, f)
+	formatsMu.Unlock()
+}
+
+// matchFormat returns the first registered format matches the header bytes.
+func matchFormat(header []byte) (Format, error) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	for _, f := range formats {
+		if f.Match(header) {
+			return f, nil
+		}
+	}
+	return Format{}, ErrFormat
+}
+
+// DetectFormat returns the name of format by reading header bytes
+// from given io.Reader.
+func DetectFormat(r io.Reader) (string, error) {
+	header := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	f, err := matchFormat(header[:n])
+	if err != nil {
+		return "", err
+	}
+	return f.Name, nil
+}
+
+// Open opens the named archive file for reading, the format is detected
+// by its header bytes regardless of file extension.
+func Open(name string) (Archive, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, header)
+	f.Close()
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+
+	format, err := matchFormat(header[:n])
+	if err != nil {
+		return nil, err
+	}
+	return format.Open(name)
+}
+
+// tempArchive is an Archive backed by a temporary file,
+// the file is removed when archive is closed.
+type tempArchive struct {
+	Archive
+	name string
+}
+
+func (a *tempArchive) Close() error {
+	defer os.Remove(a.name)
+	return a.Archive.Close()
+}
+
+// OpenReader reads an archive from given io.Reader for reading,
+// the format is detected by its header bytes. Data is saved into
+// a temporary file which is removed on close, so any changes to
+// returned archive are discarded.
+func OpenReader(r io.Reader) (Archive, error) {
+	br := bufio.NewReaderSize(r, sniffLen)
+	header, err := br.Peek(sniffLen)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	format, err := matchFormat(header)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := ioutil.TempFile("", "cae")
+	if err != nil {
+		return nil, err
+	}
+	name := f.Name()
+	_, err = io.Copy(f, br)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(name)
+		return nil, err
+	}
+
+	a, err := format.Open(name)
+	if err != nil {
+		os.Remove(name)
+		return nil, err
+	}
+	return &tempArchive{Archive: a, name: name}, nil
+}
+
+// ExtractTo extracts the whole archive or the given files to the
+// specified destination, the format is detected by its header bytes.
+func ExtractTo(srcPath, destPath string, entries ...string) error {
+	a, err := Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	return a.ExtractTo(destPath, entries...)
+}
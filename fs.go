@@ -0,0 +1,344 @@
+// Copyright 2020 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package cae
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// An FS is a read-only file system of archive entries, which can be used
+// with fs.WalkDir, fs.Glob, http.FS and template.ParseFS.
+type FS interface {
+	fs.ReadDirFS
+	fs.StatFS
+	fs.ReadFileFS
+}
+
+// An FSEntry describes an entry of archive for building FS.
+type FSEntry struct {
+	// Name is the slash-separated path of entry, it is cleaned by Clean.
+	Name string
+	// Info describes the entry, its name is replaced by base of Name.
+	Info fs.FileInfo
+	// Open opens the content of entry, it is not used for directories.
+	Open func() (io.ReadCloser, error)
+}
+
+// fsInfo overrides name of an fs.FileInfo.
+type fsInfo struct {
+	fs.FileInfo
+	name string
+}
+
+func (fi fsInfo) Name() string { return fi.name }
+
+// dirInfo describes a directory which has no entry in archive.
+type dirInfo struct {
+	name string
+}
+
+func (fi dirInfo) Name() string       { return fi.name }
+func (fi dirInfo) Size() int64        { return 0 }
+func (fi dirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0755 }
+func (fi dirInfo) ModTime() time.Time { return time.Time{} }
+func (fi dirInfo) IsDir() bool        { return true }
+func (fi dirInfo) Sys() interface{}   { return nil }
+
+// fsNode is a file or directory in FS.
+type fsNode struct {
+	info     fs.FileInfo
+	open     func() (io.ReadCloser, error)
+	children map[string]*fsNode
+}
+
+// entries returns sorted directory entries of children of node.
+func (n *fsNode) entries() []fs.DirEntry {
+	des := make([]fs.DirEntry, 0, len(n.children))
+	for _, c := range n.children {
+		des = append(des, fs.FileInfoToDirEntry(c.info))
+	}
+	sort.Slice(des, func(i, j int) bool {
+		return des[i].Name() < des[j].Name()
+	})
+	return des
+}
+
+// archiveFS implements FS with a tree of nodes.
+type archiveFS struct {
+	nodes map[string]*fsNode // Keyed by cleaned path, root is ".".
+}
+
+var _ FS = (*archiveFS)(nil)
+
+// NewFS returns a read-only FS of given entries. Upper level directories
+// which have no entry are synthesized, and later entries take precedence
+// over earlier ones with the same name.
+func NewFS(entries []FSEntry) FS {
+	fsys := &archiveFS{
+		nodes: map[string]*fsNode{
+			".": {info: dirInfo{"."}, children: make(map[string]*fsNode)},
+		},
+	}
+	for _, e := range entries {
+		name := Clean(e.Name)
+		if len(name) == 0 {
+			continue
+		}
+
+		parent := fsys.dir(path.Dir(name))
+		if parent == nil {
+			continue
+		}
+		info := fsInfo{e.Info, path.Base(name)}
+		if n := fsys.nodes[name]; n != nil {
+			// Directories keep their children, and only a directory
+			// replaces another directory.
+			if n.info.IsDir() != e.Info.IsDir() {
+				continue
+			}
+			n.info, n.open = info, e.Open
+			continue
+		}
+
+		n := &fsNode{info: info, open: e.Open}
+		if e.Info.IsDir() {
+			n.children = make(map[string]*fsNode)
+		}
+		fsys.nodes[name] = n
+		parent.children[path.Base(name)] = n
+	}
+	return fsys
+}
+
+// dir returns the directory node with given path, missing directories are
+// synthesized. It returns nil if the path or any of its parents is a file.
+func (fsys *archiveFS) dir(name string) *fsNode {
+	if n, ok := fsys.nodes[name]; ok {
+		if !n.info.IsDir() {
+			return nil
+		}
+		return n
+	}
+
+	parent := fsys.dir(path.Dir(name))
+	if parent == nil {
+		return nil
+	}
+	n := &fsNode{
+		info:     dirInfo{path.Base(name)},
+		children: make(map[string]*fsNode),
+	}
+	fsys.nodes[name] = n
+	parent.children[path.Base(name)] = n
+	return n
+}
+
+// lookup returns the node with given name.
+func (fsys *archiveFS) lookup(op, name string) (*fsNode, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	n, ok := fsys.nodes[name]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return n, nil
+}
+
+func (fsys *archiveFS) Open(name string) (fs.File, error) {
+	n, err := fsys.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if n.info.IsDir() {
+		return &fsDir{info: n.info, entries: n.entries()}, nil
+	}
+	return &fsFile{info: n.info, open: n.open}, nil
+}
+
+func (fsys *archiveFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	n, err := fsys.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	} else if !n.info.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	return n.entries(), nil
+}
+
+func (fsys *archiveFS) Stat(name string) (fs.FileInfo, error) {
+	n, err := fsys.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return n.info, nil
+}
+
+func (fsys *archiveFS) ReadFile(name string) ([]byte, error) {
+	n, err := fsys.lookup("open", name)
+	if err != nil {
+		return nil, err
+	} else if n.info.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errors.New("is a directory")}
+	}
+
+	rc, err := n.open()
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+// fsDir is an opened directory of FS.
+type fsDir struct {
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *fsDir) Close() error               { return nil }
+
+func (d *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: errors.New("is a directory")}
+}
+
+func (d *fsDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	n := len(d.entries) - d.offset
+	if count > 0 && n > count {
+		n = count
+	}
+	if n == 0 && count > 0 {
+		return nil, io.EOF
+	}
+	des := d.entries[d.offset : d.offset+n]
+	d.offset += n
+	return des, nil
+}
+
+// fsFile is an opened file of FS. The content is opened on first read,
+// and seeking backward reopens the content.
+type fsFile struct {
+	info   fs.FileInfo
+	open   func() (io.ReadCloser, error)
+	rc     io.ReadCloser
+	pos    int64 // Position of rc.
+	offset int64 // Position to read next.
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+func (f *fsFile) Read(p []byte) (int, error) {
+	if f.rc != nil && f.offset < f.pos {
+		f.rc.Close()
+		f.rc = nil
+	}
+	if f.rc == nil {
+		rc, err := f.open()
+		if err != nil {
+			return 0, &fs.PathError{Op: "read", Path: f.info.Name(), Err: err}
+		}
+		f.rc, f.pos = rc, 0
+	}
+	if f.offset > f.pos {
+		n, err := io.CopyN(ioutil.Discard, f.rc, f.offset-f.pos)
+		f.pos += n
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.rc.Read(p)
+	f.pos += int64(n)
+	f.offset = f.pos
+	return n, err
+}
+
+func (f *fsFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.Size()
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.info.Name(), Err: fs.ErrInvalid}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *fsFile) Close() error {
+	if f.rc == nil {
+		return nil
+	}
+	err := f.rc.Close()
+	f.rc = nil
+	return err
+}
+
+// WalkFS walks the file tree rooted at root of fsys, calling fn for each
+// directory and regular file with its path relative to root, root itself
+// is not visited unless it is a file. Symbolic links to regular files are
+// followed, other files and names filtered by IsFilter are skipped.
+func WalkFS(fsys fs.FS, root string, fn func(name, relPath string, fi fs.FileInfo) error) error {
+	fi, err := fs.Stat(fsys, root)
+	if err != nil {
+		return err
+	} else if !fi.IsDir() {
+		return fn(root, path.Base(root), fi)
+	}
+
+	return fs.WalkDir(fsys, root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if name == root {
+			return nil
+		} else if IsFilter(name) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		var fi fs.FileInfo
+		if d.Type()&fs.ModeSymlink != 0 {
+			fi, err = fs.Stat(fsys, name)
+		} else {
+			fi, err = d.Info()
+		}
+		if err != nil {
+			return err
+		} else if !fi.IsDir() && !fi.Mode().IsRegular() {
+			return nil
+		} else if fi.IsDir() && d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+
+		relPath := name
+		if root != "." {
+			relPath = strings.TrimPrefix(name, root+"/")
+		}
+		return fn(name, relPath, fi)
+	})
+}
@@ -1,8 +1,15 @@
 module github.com/unknwon/cae
 
-go 1.11
+go 1.17
 
 require (
 	github.com/smartystreets/goconvey v1.6.4
 	github.com/unknwon/com v1.0.1
+	golang.org/x/text v0.13.0
+)
+
+require (
+	github.com/gopherjs/gopherjs v0.0.0-20181103185306-d547d1d9531e // indirect
+	github.com/jtolds/gls v4.20.0+incompatible // indirect
+	github.com/smartystreets/assertions v0.0.0-20190116191733-b6c0e53d7304 // indirect
 )
@@ -0,0 +1,184 @@
+// Copyright 2020 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package cae
+
+import (
+	"fmt"
+	"io"
+)
+
+// A LimitError is returned when an extraction exceeds a limit of ExtractOptions.
+type LimitError struct {
+	Name  string // Name of the entry being extracted.
+	Limit string // Name of the exceeded limit, e.g. "MaxTotalSize".
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("cae: entry %q exceeds limit %s", e.Name, e.Limit)
+}
+
+// A Limiter tracks progress of an extraction and enforces limits
+// of ExtractOptions. It does not trust sizes declared by archives
+// but counts bytes that are actually read.
+type Limiter struct {
+	opts       ExtractOptions
+	entries    int
+	total      int64
+	compressed *countReader
+}
+
+// NewLimiter returns a new Limiter of given options for a single extraction.
+func NewLimiter(opts ExtractOptions) *Limiter {
+	return &Limiter{opts: opts}
+}
+
+// Entry counts a new entry to be extracted.
+func (l *Limiter) Entry(name string) error {
+	l.entries++
+	if l.opts.MaxEntries > 0 && l.entries > l.opts.MaxEntries {
+		return &LimitError{Name: name, Limit: "MaxEntries"}
+	}
+	return nil
+}
+
+// countReader counts the number of bytes read.
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// CompressedReader wraps the raw stream of archive, compressed bytes read
+// from it are used for the ratio limit of entries opened by Reader.
+func (l *Limiter) CompressedReader(r io.Reader) io.Reader {
+	l.compressed = &countReader{r: r}
+	return l.compressed
+}
+
+// limitReader enforces limits while reading an entry.
+type limitReader struct {
+	l    *Limiter
+	name string
+	r    io.Reader
+	n    int64
+	// compressed counts compressed content of the entry, nil if entry is
+	// not compressed individually.
+	compressed *countReader
+}
+
+func (r *limitReader) Read(p []byte) (int, error) {
+	// Read at most one byte beyond the limits to detect violations.
+	if max := r.remaining(); max >= 0 && int64(len(p)) > max+1 {
+		p = p[:max+1]
+	}
+
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	r.l.total += int64(n)
+	if lerr := r.check(); lerr != nil {
+		return n, lerr
+	}
+	return n, err
+}
+
+// remaining returns the number of bytes allowed to read, or -1 if unlimited.
+func (r *limitReader) remaining() int64 {
+	opts := r.l.opts
+	max, limited := int64(0), false
+	if opts.MaxEntrySize > 0 {
+		max, limited = opts.MaxEntrySize-r.n, true
+	}
+	if opts.MaxTotalSize > 0 {
+		if left := opts.MaxTotalSize - r.l.total; !limited || left < max {
+			max, limited = left, true
+		}
+	}
+	if !limited {
+		return -1
+	} else if max < 0 {
+		return 0
+	}
+	return max
+}
+
+func (r *limitReader) check() error {
+	opts := r.l.opts
+	if opts.MaxEntrySize > 0 && r.n > opts.MaxEntrySize {
+		return &LimitError{Name: r.name, Limit: "MaxEntrySize"}
+	} else if opts.MaxTotalSize > 0 && r.l.total > opts.MaxTotalSize {
+		return &LimitError{Name: r.name, Limit: "MaxTotalSize"}
+	} else if opts.MaxRatio <= 0 {
+		return nil
+	}
+
+	var uncompressed, compressed int64
+	if r.compressed != nil {
+		uncompressed, compressed = r.n, r.compressed.n
+	} else if r.l.compressed != nil {
+		uncompressed, compressed = r.l.total, r.l.compressed.n
+	} else {
+		return nil
+	}
+	if compressed < 1 {
+		compressed = 1
+	}
+	if float64(uncompressed)/float64(compressed) > opts.MaxRatio {
+		return &LimitError{Name: r.name, Limit: "MaxRatio"}
+	}
+	return nil
+}
+
+// Reader returns an io.Reader reads entry data from r and returns a
+// *LimitError once any limit is exceeded. The ratio limit is checked
+// against compressed bytes read through CompressedReader if any.
+func (l *Limiter) Reader(name string, r io.Reader) io.Reader {
+	return &limitReader{
+		l:    l,
+		name: name,
+		r:    r,
+	}
+}
+
+// limitReadCloser is a limitReader which closes the entry.
+type limitReadCloser struct {
+	*limitReader
+	io.Closer
+}
+
+// EntryReader is like Reader for entries compressed individually, it opens
+// the entry by open which decompresses content read from raw. The ratio
+// limit is checked against compressed bytes actually read from raw instead
+// of the size declared by archive.
+func (l *Limiter) EntryReader(name string, raw io.Reader, open func(io.Reader) (io.ReadCloser, error)) (io.ReadCloser, error) {
+	cr := &countReader{r: raw}
+	rc, err := open(cr)
+	if err != nil {
+		return nil, err
+	}
+	return &limitReadCloser{
+		limitReader: &limitReader{
+			l:          l,
+			name:       name,
+			r:          rc,
+			compressed: cr,
+		},
+		Closer: rc,
+	}, nil
+}
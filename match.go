@@ -0,0 +1,331 @@
+// Copyright 2020 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package cae
+
+import (
+	"bufio"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// A Matcher selects entries by their names, which are slash-separated
+// paths in archive and directory names end with a slash.
+type Matcher interface {
+	Match(name string) bool
+}
+
+// A MatchFunc is an adapter to use ordinary functions as Matcher.
+type MatchFunc func(name string) bool
+
+func (f MatchFunc) Match(name string) bool {
+	return f(name)
+}
+
+// Matches returns true if m is nil or matches the entry with given name,
+// a slash is appended to name of directory if missing.
+func Matches(m Matcher, name string, isDir bool) bool {
+	if m == nil {
+		return true
+	} else if isDir && !strings.HasSuffix(name, "/") {
+		name += "/"
+	}
+	return m.Match(name)
+}
+
+// A DirSkipper is a Matcher which can tell a directory and all of its
+// children are not matched, so that walking into it can be skipped.
+type DirSkipper interface {
+	Matcher
+	SkipDir(dir string) bool
+}
+
+// SkipDir returns true if m is a DirSkipper and the directory with given
+// name and all of its children are not matched by m.
+func SkipDir(m Matcher, dir string) bool {
+	ds, ok := m.(DirSkipper)
+	return ok && ds.SkipDir(dir)
+}
+
+// splitName splits name into path elements, and returns whether it is
+// a directory.
+func splitName(name string) ([]string, bool) {
+	isDir := strings.HasSuffix(name, "/")
+	name = Clean(name)
+	if len(name) == 0 {
+		return nil, isDir
+	}
+	return strings.Split(name, "/"), isDir
+}
+
+// names matches names and children of directories.
+type names map[string]bool
+
+// Names returns a Matcher which matches given names, and all children of
+// given directories, e.g. "docs" or "docs/" matches "docs/README.md".
+func Names(list ...string) Matcher {
+	m := make(names, len(list))
+	for _, name := range list {
+		if name = Clean(name); len(name) > 0 {
+			m[name] = true
+		}
+	}
+	return m
+}
+
+func (m names) Match(name string) bool {
+	for name = Clean(name); len(name) > 0; name = path.Dir(name) {
+		if m[name] {
+			return true
+		} else if !strings.Contains(name, "/") {
+			break
+		}
+	}
+	return false
+}
+
+// matchElems returns true if path elements match pattern elements,
+// "**" matches zero or more elements.
+func matchElems(pattern, elems []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for len(pattern) > 0 && pattern[0] == "**" {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := range elems {
+				if matchElems(pattern, elems[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(elems) == 0 {
+			return false
+		} else if ok, _ := path.Match(pattern[0], elems[0]); !ok {
+			return false
+		}
+		pattern, elems = pattern[1:], elems[1:]
+	}
+	return len(elems) == 0
+}
+
+// compileGlob splits pattern into elements and checks their syntax.
+func compileGlob(pattern string) ([]string, error) {
+	elems := strings.Split(strings.Trim(pattern, "/"), "/")
+	for _, elem := range elems {
+		if _, err := path.Match(elem, ""); err != nil {
+			return nil, err
+		}
+	}
+	return elems, nil
+}
+
+// globs matches names by glob patterns.
+type globs [][]string
+
+// Glob returns a Matcher which matches names by any of given patterns.
+// Patterns match whole names with syntax of path.Match, and "**" matches
+// zero or more directories, e.g. "docs/**" matches "docs/" and everything
+// in it, and "**/*.psd" matches ".psd" files at any level.
+func Glob(patterns ...string) (Matcher, error) {
+	m := make(globs, 0, len(patterns))
+	for _, pattern := range patterns {
+		elems, err := compileGlob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		m = append(m, elems)
+	}
+	return m, nil
+}
+
+func (m globs) Match(name string) bool {
+	elems, _ := splitName(name)
+	for _, pattern := range m {
+		if matchElems(pattern, elems) {
+			return true
+		}
+	}
+	return false
+}
+
+// regexps matches names by regular expressions.
+type regexps []*regexp.Regexp
+
+// Regexp returns a Matcher which matches names by any of given regular
+// expressions, names of directories end with a slash.
+func Regexp(exprs ...string) (Matcher, error) {
+	m := make(regexps, 0, len(exprs))
+	for _, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		m = append(m, re)
+	}
+	return m, nil
+}
+
+func (m regexps) Match(name string) bool {
+	for _, re := range m {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// gitignoreRule is a pattern line of gitignore.
+type gitignoreRule struct {
+	elems   []string
+	negate  bool
+	dirOnly bool
+}
+
+// gitignore matches names by gitignore rules.
+type gitignore []gitignoreRule
+
+// Gitignore returns a Matcher which matches names ignored by given lines
+// of gitignore syntax, including negation with "!", directory-only rules
+// with trailing slash, and rules without slash matching at any level.
+// Like git, children of an ignored directory are ignored as well.
+func Gitignore(lines ...string) (Matcher, error) {
+	m := make(gitignore, 0, len(lines))
+	for _, line := range lines {
+		if !strings.HasSuffix(line, "\\ ") {
+			line = strings.TrimRight(line, " \t\r")
+		}
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		var rule gitignoreRule
+		if line[0] == '!' {
+			rule.negate = true
+			line = line[1:]
+		} else if line[0] == '\\' {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if len(strings.Trim(line, "/")) == 0 {
+			continue
+		} else if !strings.Contains(line, "/") {
+			line = "**/" + line
+		}
+
+		elems, err := compileGlob(line)
+		if err != nil {
+			return nil, err
+		}
+		rule.elems = elems
+		m = append(m, rule)
+	}
+	return m, nil
+}
+
+// ParseGitignore reads lines of gitignore syntax from r, e.g. a .gitignore
+// file, and returns a Matcher of them.
+func ParseGitignore(r io.Reader) (Matcher, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return Gitignore(lines...)
+}
+
+// ignored returns true if path elements are ignored by the last matched rule.
+func (m gitignore) ignored(elems []string, isDir bool) bool {
+	ignored := false
+	for _, rule := range m {
+		if rule.dirOnly && !isDir {
+			continue
+		} else if matchElems(rule.elems, elems) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+func (m gitignore) Match(name string) bool {
+	elems, isDir := splitName(name)
+	for i := 1; i <= len(elems); i++ {
+		if m.ignored(elems[:i], i < len(elems) || isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// selector matches names by include and exclude matchers.
+type selector struct {
+	include, exclude Matcher
+}
+
+// Select returns a Matcher which matches names matched by include, or all
+// names if include is nil, except the ones matched by exclude. A directory
+// matched by exclude is excluded along with all of its children, e.g.
+// Select(nil, Gitignore("node_modules/")) skips "node_modules" entirely.
+func Select(include, exclude Matcher) Matcher {
+	return selector{include, exclude}
+}
+
+func (m selector) Match(name string) bool {
+	if m.include != nil && !m.include.Match(name) {
+		return false
+	} else if m.exclude == nil {
+		return true
+	}
+
+	elems, isDir := splitName(name)
+	for i := 1; i <= len(elems); i++ {
+		sub := strings.Join(elems[:i], "/")
+		if i < len(elems) || isDir {
+			sub += "/"
+		}
+		if m.exclude.Match(sub) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m selector) SkipDir(dir string) bool {
+	return m.exclude != nil && m.exclude.Match(strings.TrimSuffix(dir, "/")+"/")
+}
+
+// All returns a Matcher which matches names matched by all of given
+// matchers, nil matchers are ignored.
+func All(ms ...Matcher) Matcher {
+	return MatchFunc(func(name string) bool {
+		for _, m := range ms {
+			if m != nil && !m.Match(name) {
+				return false
+			}
+		}
+		return true
+	})
+}
@@ -0,0 +1,104 @@
+// Copyright 2020 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package cae
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMatcher(t *testing.T) {
+	Convey("Match names of entries", t, func() {
+		Convey("Names with children of directories", func() {
+			m := Names("docs/", "hello")
+			So(m.Match("docs/"), ShouldBeTrue)
+			So(m.Match("docs/a/b.md"), ShouldBeTrue)
+			So(m.Match("hello"), ShouldBeTrue)
+			So(m.Match("docs2/a.md"), ShouldBeFalse)
+			So(m.Match("hello2"), ShouldBeFalse)
+		})
+
+		Convey("Glob patterns", func() {
+			m, err := Glob("docs/**", "**/*.go", "*.m?")
+			So(err, ShouldBeNil)
+			So(m.Match("docs/"), ShouldBeTrue)
+			So(m.Match("docs/a/b.psd"), ShouldBeTrue)
+			So(m.Match("main.go"), ShouldBeTrue)
+			So(m.Match("cmd/cae/main.go"), ShouldBeTrue)
+			So(m.Match("README.md"), ShouldBeTrue)
+			So(m.Match("dir/README.md"), ShouldBeFalse)
+			So(m.Match("src/"), ShouldBeFalse)
+
+			_, err = Glob("[")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Regular expressions", func() {
+			m, err := Regexp(`\.(jpe?g|png)$`, `^vendor/`)
+			So(err, ShouldBeNil)
+			So(m.Match("img/a.jpeg"), ShouldBeTrue)
+			So(m.Match("vendor/"), ShouldBeTrue)
+			So(m.Match("img/"), ShouldBeFalse)
+
+			_, err = Regexp("(")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Gitignore rules", func() {
+			m, err := ParseGitignore(strings.NewReader(`# Comment
+node_modules/
+*.log
+!keep.log
+/build
+docs/**/*.tmp
+`))
+			So(err, ShouldBeNil)
+			So(m.Match("node_modules/"), ShouldBeTrue)
+			So(m.Match("web/node_modules/a/b.js"), ShouldBeTrue)
+			So(m.Match("node_modules"), ShouldBeFalse)
+			So(m.Match("a.log"), ShouldBeTrue)
+			So(m.Match("logs/keep.log"), ShouldBeFalse)
+			So(m.Match("build/main"), ShouldBeTrue)
+			So(m.Match("src/build/main"), ShouldBeFalse)
+			So(m.Match("docs/a/b/c.tmp"), ShouldBeTrue)
+			So(m.Match("main.go"), ShouldBeFalse)
+		})
+
+		Convey("Include and exclude", func() {
+			include, err := Glob("docs/**")
+			So(err, ShouldBeNil)
+			exclude, err := Gitignore("*.psd", "drafts/")
+			So(err, ShouldBeNil)
+			m := Select(include, exclude)
+			So(m.Match("docs/README.md"), ShouldBeTrue)
+			So(m.Match("docs/logo.psd"), ShouldBeFalse)
+			So(m.Match("docs/drafts/a.md"), ShouldBeFalse)
+			So(m.Match("src/main.go"), ShouldBeFalse)
+			So(SkipDir(m, "docs/drafts"), ShouldBeTrue)
+			So(SkipDir(m, "docs"), ShouldBeFalse)
+			So(SkipDir(include, "docs/drafts"), ShouldBeFalse)
+
+			exclude, err = Glob("vendor")
+			So(err, ShouldBeNil)
+			m = Select(nil, exclude)
+			So(m.Match("vendor/a.go"), ShouldBeFalse)
+			So(m.Match("main.go"), ShouldBeTrue)
+			So(Matches(m, "vendor", true), ShouldBeFalse)
+			So(Matches(nil, "vendor", true), ShouldBeTrue)
+		})
+	})
+}
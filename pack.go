@@ -0,0 +1,54 @@
+// Copyright 2020 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package cae
+
+import (
+	"os"
+	"time"
+)
+
+// An IDMapFunc maps user and group IDs of an entry to new ones.
+type IDMapFunc func(uid, gid int) (int, int)
+
+// FixedOwner returns an IDMapFunc maps every entry to given user and group IDs.
+func FixedOwner(uid, gid int) IDMapFunc {
+	return func(int, int) (int, int) {
+		return uid, gid
+	}
+}
+
+// EntryOptions contains options for entries added from memory or io.Reader.
+type EntryOptions struct {
+	// Mode is the permission bits of entry, 0644 is used if zero.
+	Mode os.FileMode
+	// ModTime is the modification time of entry, current time is used if zero.
+	ModTime time.Time
+}
+
+// Perm returns the permission bits of entry.
+func (opts EntryOptions) Perm() os.FileMode {
+	if opts.Mode.Perm() == 0 {
+		return 0644
+	}
+	return opts.Mode.Perm()
+}
+
+// Time returns the modification time of entry.
+func (opts EntryOptions) Time() time.Time {
+	if opts.ModTime.IsZero() {
+		return time.Now()
+	}
+	return opts.ModTime
+}
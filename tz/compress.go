@@ -0,0 +1,264 @@
+// Copyright 2020 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package tz
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+// Names of supported compressions, compressors and decompressors of
+// Zstd, Xz and Lz4 need to be registered before use.
+const (
+	Tar   = "tar" // No compression.
+	Gzip  = "gzip"
+	Bzip2 = "bzip2"
+	Zstd  = "zstd"
+	Xz    = "xz"
+	Lz4   = "lz4"
+)
+
+// A Compressor returns a new compressing writer, writing to w.
+type Compressor func(w io.Writer) (io.WriteCloser, error)
+
+// A Decompressor returns a new decompressing reader, reading from r.
+type Decompressor func(r io.Reader) (io.ReadCloser, error)
+
+// compression describes a compression of tar stream.
+type compression struct {
+	name         string
+	exts         []string
+	magic        []byte
+	compressor   Compressor
+	decompressor Decompressor
+}
+
+// nopWriteCloser is an io.WriteCloser with a no-op Close method.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+var (
+	compressionsMu sync.RWMutex
+	compressions   = []*compression{
+		{
+			name: Tar,
+			exts: []string{".tar"},
+			compressor: func(w io.Writer) (io.WriteCloser, error) {
+				return nopWriteCloser{w}, nil
+			},
+			decompressor: func(r io.Reader) (io.ReadCloser, error) {
+				return ioutil.NopCloser(r), nil
+			},
+		},
+		{
+			name:  Gzip,
+			exts:  []string{".tar.gz", ".tgz"},
+			magic: []byte{0x1f, 0x8b},
+			compressor: func(w io.Writer) (io.WriteCloser, error) {
+				return gzip.NewWriter(w), nil
+			},
+			decompressor: func(r io.Reader) (io.ReadCloser, error) {
+				return gzip.NewReader(r)
+			},
+		},
+		{
+			name:  Bzip2,
+			exts:  []string{".tar.bz2", ".tbz2", ".tbz"},
+			magic: []byte("BZh"),
+			decompressor: func(r io.Reader) (io.ReadCloser, error) {
+				return ioutil.NopCloser(bzip2.NewReader(r)), nil
+			},
+		},
+		{
+			name:  Zstd,
+			exts:  []string{".tar.zst", ".tzst"},
+			magic: []byte{0x28, 0xb5, 0x2f, 0xfd},
+		},
+		{
+			name:  Xz,
+			exts:  []string{".tar.xz", ".txz"},
+			magic: []byte{0xfd, '7', 'z', 'X', 'Z', 0x00},
+		},
+		{
+			name:  Lz4,
+			exts:  []string{".tar.lz4"},
+			magic: []byte{0x04, 0x22, 0x4d, 0x18},
+		},
+	}
+)
+
+// findCompression returns the compression with given name,
+// or nil if not found.
+func findCompression(name string) *compression {
+	for _, c := range compressions {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// RegisterCompressor registers or overrides the compressor of given
+// compression name, e.g. Zstd. It panics if the name is unknown.
+func RegisterCompressor(name string, comp Compressor) {
+	compressionsMu.Lock()
+	defer compressionsMu.Unlock()
+	c := findCompression(name)
+	if c == nil {
+		panic("tz: unknown compression " + name)
+	}
+	c.compressor = comp
+}
+
+// RegisterDecompressor registers or overrides the decompressor of given
+// compression name, e.g. Zstd. It panics if the name is unknown.
+func RegisterDecompressor(name string, dcomp Decompressor) {
+	compressionsMu.Lock()
+	defer compressionsMu.Unlock()
+	c := findCompression(name)
+	if c == nil {
+		panic("tz: unknown compression " + name)
+	}
+	c.decompressor = dcomp
+}
+
+// compressionByExt returns the compression name by file extension,
+// it returns Gzip if no one matches.
+func compressionByExt(name string) string {
+	compressionsMu.RLock()
+	defer compressionsMu.RUnlock()
+	name = strings.ToLower(name)
+	for _, c := range compressions {
+		for _, ext := range c.exts {
+			if strings.HasSuffix(name, ext) {
+				return c.name
+			}
+		}
+	}
+	return Gzip
+}
+
+// isTar returns true if header bytes are an uncompressed tar header.
+func isTar(header []byte) bool {
+	return len(header) >= 262 && string(header[257:262]) == "ustar"
+}
+
+// detectCompression returns the compression name by header bytes.
+func detectCompression(header []byte) (string, bool) {
+	compressionsMu.RLock()
+	defer compressionsMu.RUnlock()
+	for _, c := range compressions {
+		if len(c.magic) > 0 && bytes.HasPrefix(header, c.magic) {
+			return c.name, true
+		}
+	}
+	return Tar, isTar(header)
+}
+
+// endBlocksLen is the length of end-of-archive marker of tar stream,
+// i.e. two zero blocks.
+const endBlocksLen = 1024
+
+// gzipTrailer is a gzip member which contains only the end-of-archive
+// marker of tar stream, stored without compression. Gzip archives are
+// written with it as the last member, so that new entries can be appended
+// by replacing it without decompressing existing members.
+var gzipTrailer = func() []byte {
+	b := []byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 0xff}
+	// Single final stored block.
+	b = append(b, 1, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint16(b[11:], endBlocksLen)
+	binary.LittleEndian.PutUint16(b[13:], ^uint16(endBlocksLen))
+	zeros := make([]byte, endBlocksLen)
+	b = append(b, zeros...)
+	b = append(b, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(b[len(b)-8:], crc32.ChecksumIEEE(zeros))
+	binary.LittleEndian.PutUint32(b[len(b)-4:], endBlocksLen)
+	return b
+}()
+
+// closeTar closes tar.Writer and compressing writer cw which writes to w.
+// For Gzip, the end-of-archive marker is written as a separate member.
+func closeTar(tw *tar.Writer, cw io.WriteCloser, w io.Writer, compression string) error {
+	if compression != Gzip {
+		if err := tw.Close(); err != nil {
+			return err
+		}
+		return cw.Close()
+	}
+
+	if err := tw.Flush(); err != nil {
+		return err
+	} else if err = cw.Close(); err != nil {
+		return err
+	}
+	_, err := w.Write(gzipTrailer)
+	return err
+}
+
+// newCompressor returns a new compressing writer of given compression name.
+func newCompressor(name string, w io.Writer) (io.WriteCloser, error) {
+	compressionsMu.RLock()
+	c := findCompression(name)
+	var comp Compressor
+	if c != nil {
+		comp = c.compressor
+	}
+	compressionsMu.RUnlock()
+
+	if c == nil {
+		return nil, fmt.Errorf("tz: unknown compression %q", name)
+	} else if comp == nil {
+		return nil, fmt.Errorf("tz: no compressor registered for %s", name)
+	}
+	return comp(w)
+}
+
+// decompress detects compression of given stream and returns the
+// decompressing reader and compression name. It falls back to no
+// compression if the compression is not recognized.
+func decompress(r io.Reader) (io.ReadCloser, string, error) {
+	br := bufio.NewReaderSize(r, 512)
+	header, err := br.Peek(512)
+	if err != nil && err != io.EOF {
+		return nil, "", err
+	}
+	name, _ := detectCompression(header)
+
+	compressionsMu.RLock()
+	dcomp := findCompression(name).decompressor
+	compressionsMu.RUnlock()
+	if dcomp == nil {
+		return nil, "", fmt.Errorf("tz: no decompressor registered for %s", name)
+	}
+	rc, err := dcomp(br)
+	if err != nil {
+		return nil, "", err
+	}
+	return rc, name, nil
+}
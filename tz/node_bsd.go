@@ -0,0 +1,34 @@
+// Copyright 2020 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package tz
+
+import (
+	"archive/tar"
+	"fmt"
+	"runtime"
+	"syscall"
+)
+
+// mknod creates FIFO from tar.Header, device files are only supported
+// on Linux.
+func mknod(h *tar.Header, filePath string) error {
+	if h.Typeflag != tar.TypeFifo {
+		return fmt.Errorf("tz: creating device file %q is not supported on %s", h.Name, runtime.GOOS)
+	}
+	return syscall.Mkfifo(filePath, uint32(h.Mode&07777))
+}
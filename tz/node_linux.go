@@ -0,0 +1,39 @@
+// Copyright 2020 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package tz
+
+import (
+	"archive/tar"
+	"syscall"
+)
+
+// mkdev returns the device number of given major and minor numbers.
+func mkdev(major, minor int64) int {
+	return int((major&0xfff)<<8 | (minor & 0xff) | (major&^0xfff)<<32 | (minor&^0xff)<<12)
+}
+
+// mknod creates a FIFO, character or block device file from tar.Header.
+func mknod(h *tar.Header, filePath string) error {
+	mode := uint32(h.Mode & 07777)
+	switch h.Typeflag {
+	case tar.TypeFifo:
+		mode |= syscall.S_IFIFO
+	case tar.TypeChar:
+		mode |= syscall.S_IFCHR
+	case tar.TypeBlock:
+		mode |= syscall.S_IFBLK
+	}
+	return syscall.Mknod(filePath, mode, mkdev(h.Devmajor, h.Devminor))
+}
@@ -0,0 +1,30 @@
+// Copyright 2020 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package tz
+
+import (
+	"archive/tar"
+	"fmt"
+	"runtime"
+)
+
+// mknod always returns an error because creating special files is
+// not supported on this platform.
+func mknod(h *tar.Header, filePath string) error {
+	return fmt.Errorf("tz: creating special file %q is not supported on %s", h.Name, runtime.GOOS)
+}
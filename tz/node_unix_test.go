@@ -0,0 +1,66 @@
+// Copyright 2020 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package tz
+
+import (
+	"archive/tar"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/unknwon/cae"
+)
+
+func TestSpecialFiles(t *testing.T) {
+	Convey("Pack and extract hard links and FIFOs", t, func() {
+		src := path.Join(os.TempDir(), "testdata/TestSpecialFiles")
+		dest := path.Join(os.TempDir(), "testdata/TestSpecialFiles.out")
+		name := path.Join(os.TempDir(), "testdata/TestSpecialFiles.tar.gz")
+		os.RemoveAll(src)
+		os.RemoveAll(dest)
+		So(os.MkdirAll(src, os.ModePerm), ShouldBeNil)
+		So(cae.Copy(path.Join(src, "a"), "testdata/README.txt"), ShouldBeNil)
+		So(os.Link(path.Join(src, "a"), path.Join(src, "b")), ShouldBeNil)
+		So(syscall.Mkfifo(path.Join(src, "fifo"), 0644), ShouldBeNil)
+
+		So(PackTo(src, name), ShouldBeNil)
+
+		z, err := Open(name)
+		So(err, ShouldBeNil)
+		types := make(map[byte]int)
+		for _, h := range z.File {
+			types[h.Typeflag]++
+		}
+		So(types[tar.TypeReg], ShouldEqual, 1)
+		So(types[tar.TypeLink], ShouldEqual, 1)
+		So(types[tar.TypeFifo], ShouldEqual, 1)
+
+		So(z.ExtractTo(dest), ShouldBeNil)
+		fa, err := os.Stat(path.Join(dest, "a"))
+		So(err, ShouldBeNil)
+		fb, err := os.Stat(path.Join(dest, "b"))
+		So(err, ShouldBeNil)
+		So(os.SameFile(fa, fb), ShouldBeTrue)
+
+		fi, err := os.Lstat(path.Join(dest, "fifo"))
+		So(err, ShouldBeNil)
+		So(fi.Mode()&os.ModeNamedPipe, ShouldNotEqual, 0)
+	})
+}
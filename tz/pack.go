@@ -0,0 +1,52 @@
+// Copyright 2020 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package tz
+
+import (
+	"archive/tar"
+
+	"github.com/unknwon/cae"
+)
+
+// PackOptions contains options for packing archives.
+type PackOptions struct {
+	// Compression is the name of compression, e.g. Tar for no compression.
+	// PackFS uses Gzip and PackToWithOptions chooses by extension of
+	// destination if empty.
+	Compression string
+	// IDMap maps owner of every entry, user and group names are cleared
+	// when it is set so that IDs are authoritative.
+	IDMap cae.IDMapFunc
+	// Uname and Gname override user and group names of every entry
+	// if not empty.
+	Uname, Gname string
+	// PackMatcher selects files to be packed by their names in archive
+	// if not nil, directories matched by cae.DirSkipper are not walked into.
+	PackMatcher cae.Matcher
+}
+
+// setOwner applies owner options to tar.Header.
+func setOwner(h *tar.Header, opts PackOptions) {
+	if opts.IDMap != nil {
+		h.Uid, h.Gid = opts.IDMap(h.Uid, h.Gid)
+		h.Uname, h.Gname = "", ""
+	}
+	if len(opts.Uname) > 0 {
+		h.Uname = opts.Uname
+	}
+	if len(opts.Gname) > 0 {
+		h.Gname = opts.Gname
+	}
+}
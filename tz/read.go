@@ -16,10 +16,13 @@ package tz
 
 import (
 	"archive/tar"
-	"compress/gzip"
+	"errors"
 	"io"
+	"io/ioutil"
+	"math"
 	"os"
 	"strings"
+	"sync"
 
 	"github.com/unknwon/cae"
 )
@@ -28,51 +31,113 @@ import (
 type ReadCloser struct {
 	f    *os.File
 	File []*tar.Header
+
+	compression string
+	// offsets contains offsets of contents of entries in the tar stream,
+	// they are recorded when reading headers.
+	offsets []int64
+
+	// spill contains the decompressed tar stream of compressed archive,
+	// it is created on the first access of contents so that the archive
+	// is decompressed only once.
+	spillOnce sync.Once
+	spill     *os.File
+	spillErr  error
 }
 
-// Close closes the tar.gz file, rendering it unusable for I/O.
+// Close closes the tar file, rendering it unusable for I/O.
 func (rc *ReadCloser) Close() error {
+	if rc.spill != nil {
+		rc.spill.Close()
+		os.Remove(rc.spill.Name())
+		rc.spill = nil
+	}
 	return rc.f.Close()
 }
 
-// openFile opens a tar.gz file with gzip and tar decoders.
-func openFile(name string) (*tar.Reader, *os.File, error) {
+// newReader returns a tar.Reader decodes tar stream from given io.Reader,
+// the compression is detected by header bytes. It returns the decompressing
+// reader to be closed and the compression name as well.
+func newReader(r io.Reader) (*tar.Reader, io.Closer, string, error) {
+	rc, name, err := decompress(r)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return tar.NewReader(rc), rc, name, nil
+}
+
+// fileCloser closes the decompressing reader and the underlying file.
+type fileCloser struct {
+	rc io.Closer
+	f  *os.File
+}
+
+func (c *fileCloser) Close() error {
+	c.rc.Close()
+	return c.f.Close()
+}
+
+// openFile opens a tar file with detected decompressor and tar decoder,
+// it returns the compression name as well.
+func openFile(name string) (*tar.Reader, io.Closer, string, error) {
 	f, err := os.Open(name)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, "", err
 	}
 
-	gr, err := gzip.NewReader(f)
+	tr, rc, compression, err := newReader(f)
 	if err != nil {
 		f.Close()
-		return nil, nil, err
+		return nil, nil, "", err
 	}
-
-	return tar.NewReader(gr), f, nil
+	return tr, &fileCloser{rc, f}, compression, nil
 }
 
-// openReader opens the tar.gz file specified by name and return a tar.Reader.
-func openReader(name string) (*ReadCloser, error) {
-	tr, f, err := openFile(name)
+// openReader opens the tar file specified by name and return a ReadCloser
+// and the compression name.
+func openReader(name string) (*ReadCloser, string, error) {
+	f, err := os.Open(name)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	r := new(ReadCloser)
-	if err := r.init(tr); err != nil {
-		return nil, err
+	dr, compression, err := decompress(f)
+	if err != nil {
+		f.Close()
+		return nil, "", err
+	}
+	defer dr.Close()
+
+	r := &ReadCloser{compression: compression}
+	if err := r.init(dr); err != nil {
+		f.Close()
+		return nil, "", err
 	}
 	r.f = f
-	return r, nil
+	return r, compression, nil
 }
 
-// init initializes a new ReadCloser.
-func (rc *ReadCloser) init(r *tar.Reader) error {
-	defer rc.Close()
+// countReader counts the number of bytes read.
+type countReader struct {
+	r io.Reader
+	n int64
+}
 
+func (r *countReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// init initializes a new ReadCloser by reading headers from given
+// uncompressed tar stream.
+func (rc *ReadCloser) init(r io.Reader) error {
+	cr := &countReader{r: r}
+	tr := tar.NewReader(cr)
 	rc.File = make([]*tar.Header, 0, 10)
+	rc.offsets = make([]int64, 0, 10)
 	for {
-		h, err := r.Next()
+		h, err := tr.Next()
 		if err == io.EOF {
 			break
 		} else if err != nil {
@@ -80,25 +145,101 @@ func (rc *ReadCloser) init(r *tar.Reader) error {
 		}
 
 		rc.File = append(rc.File, h)
+		rc.offsets = append(rc.offsets, cr.n)
 	}
 	return nil
 }
 
+// isSparse returns true if the entry is a sparse file, whose content
+// is not stored as it is.
+func isSparse(h *tar.Header) bool {
+	if h.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for k := range h.PAXRecords {
+		if strings.HasPrefix(k, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+// stream returns the uncompressed tar stream which offsets refer to.
+// Compressed archive is decompressed to a temporary file on first call.
+func (rc *ReadCloser) stream() (io.ReaderAt, error) {
+	if rc.compression == Tar {
+		return rc.f, nil
+	}
+
+	rc.spillOnce.Do(func() {
+		dr, _, err := decompress(io.NewSectionReader(rc.f, 0, math.MaxInt64))
+		if err != nil {
+			rc.spillErr = err
+			return
+		}
+		defer dr.Close()
+
+		f, err := ioutil.TempFile("", "cae")
+		if err != nil {
+			rc.spillErr = err
+			return
+		}
+		if _, err = io.Copy(f, dr); err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			rc.spillErr = err
+			return
+		}
+		rc.spill = f
+	})
+	if rc.spillErr != nil {
+		return nil, rc.spillErr
+	}
+	return rc.spill, nil
+}
+
+// openEntry opens the content of entry with given index of File. Contents
+// are read from the uncompressed tar stream by the offsets directly, except
+// sparse files whose headers are decoded again to expand holes.
+func (rc *ReadCloser) openEntry(idx int) (io.ReadCloser, error) {
+	r, err := rc.stream()
+	if err != nil {
+		return nil, err
+	}
+
+	h := rc.File[idx]
+	if !isSparse(h) {
+		return ioutil.NopCloser(io.NewSectionReader(r, rc.offsets[idx], h.Size)), nil
+	}
+
+	tr := tar.NewReader(io.NewSectionReader(r, 0, math.MaxInt64))
+	for i := 0; i <= idx; i++ {
+		if _, err = tr.Next(); err != nil {
+			return nil, err
+		}
+	}
+	return ioutil.NopCloser(tr), nil
+}
+
 // syncFiles syncs file information from file system to memroy object.
 func (tz *TzArchive) syncFiles() {
 	tz.files = make([]*File, tz.NumFiles)
 	for i, f := range tz.File {
-		tz.files[i] = &File{}
-		tz.files[i].Header = f
-		tz.files[i].Name = cae.Clean(strings.ReplaceAll(f.Name, "\\", "/"))
-		if f.FileInfo().IsDir() && !strings.HasSuffix(tz.files[i].Name, "/") {
-			tz.files[i].Name += "/"
+		// Keep a copy of original header, only the name is normalized.
+		h := *f
+		h.Name = cae.Clean(strings.ReplaceAll(f.Name, "\\", "/"))
+		if f.FileInfo().IsDir() && !strings.HasSuffix(h.Name, "/") {
+			h.Name += "/"
+		}
+		tz.files[i] = &File{
+			Header: &h,
+			h:      f,
 		}
 	}
 }
 
 // Open is the generalized open call; most users will use Open
-// instead. It opens the named tar.gz file with specified flag
+// instead. It opens the named tar file with specified flag
 // (O_RDONLY etc.) if applicable. If successful,
 // methods on the returned TzArchive can be used for I/O.
 // If there is an error, it will be of type *PathError.
@@ -108,30 +249,140 @@ func (tz *TzArchive) Open(name string, flag int, perm os.FileMode) error {
 		if err != nil {
 			return err
 		}
+		defer fw.Close()
 
-		gw := gzip.NewWriter(fw)
-		tw := tar.NewWriter(gw)
-		if err = tw.Close(); err != nil {
+		cw, err := newCompressor(compressionByExt(name), fw)
+		if err != nil {
 			return err
-		} else if err = gw.Close(); err != nil {
+		}
+		if err = closeTar(tar.NewWriter(cw), cw, fw, compressionByExt(name)); err != nil {
 			return err
 		} else if err = fw.Close(); err != nil {
 			return err
 		}
 	}
 
-	rc, err := openReader(name)
+	rc, compression, err := openReader(name)
 	if err != nil {
 		return err
 	}
 
 	tz.ReadCloser = rc
+	tz.Compression = compression
 	tz.FileName = name
 	tz.NumFiles = len(rc.File)
 	tz.Flag = flag
 	tz.Permission = perm
 	tz.isHasChanged = false
+	tz.view = nil
 
 	tz.syncFiles()
 	return nil
 }
+
+// View returns a read-only file system of entries in the opened archive,
+// changes are not visible until flushed. Directories which have no entry
+// are synthesized, and hard links are resolved to their targets.
+func (tz *TzArchive) View() cae.FS {
+	if tz.view != nil {
+		return tz.view
+	}
+
+	var entries []cae.FSEntry
+	if tz.ReadCloser != nil {
+		entries = make([]cae.FSEntry, 0, len(tz.File))
+		indexes := make(map[string]int) // Index of latest entry of each name.
+		for i, h := range tz.File {
+			name := cae.Clean(strings.ReplaceAll(h.Name, "\\", "/"))
+			idx := i
+			if h.Typeflag == tar.TypeLink {
+				target, ok := indexes[cae.Clean(strings.ReplaceAll(h.Linkname, "\\", "/"))]
+				if !ok {
+					continue
+				}
+				idx = target
+			}
+			indexes[name] = idx
+
+			rc := tz.ReadCloser
+			entries = append(entries, cae.FSEntry{
+				Name: name,
+				Info: rc.File[idx].FileInfo(),
+				Open: func() (io.ReadCloser, error) {
+					return rc.openEntry(idx)
+				},
+			})
+		}
+	}
+	tz.view = cae.NewFS(entries)
+	return tz.view
+}
+
+// linkTarget returns the index of target of hard link with given index
+// of File, that is the latest entry with the link name before it.
+func (rc *ReadCloser) linkTarget(idx int) (int, bool) {
+	target := cae.Clean(strings.ReplaceAll(rc.File[idx].Linkname, "\\", "/"))
+	for i := idx - 1; i >= 0; i-- {
+		if cae.Clean(strings.ReplaceAll(rc.File[i].Name, "\\", "/")) == target {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// openFile opens the content of an entry, which may be added later and
+// not flushed yet. Hard links are resolved to their targets.
+func (tz *TzArchive) openFile(f *File) (io.ReadCloser, error) {
+	switch {
+	case f.isStream:
+		return nil, &os.PathError{Op: "open", Path: f.Name, Err: errors.New("content of io.Reader is not available before flushing")}
+	case f.open != nil:
+		return f.open()
+	case f.h != nil:
+		for i, h := range tz.File {
+			if h != f.h {
+				continue
+			}
+			if h.Typeflag == tar.TypeLink {
+				target, ok := tz.ReadCloser.linkTarget(i)
+				if !ok {
+					return nil, &os.PathError{Op: "open", Path: f.Name, Err: errors.New("hard link target not found")}
+				}
+				i = target
+			}
+			return tz.ReadCloser.openEntry(i)
+		}
+		return nil, &os.PathError{Op: "open", Path: f.Name, Err: os.ErrNotExist}
+	case len(f.absPath) > 0:
+		return os.Open(f.absPath)
+	}
+	return ioutil.NopCloser(strings.NewReader("")), nil
+}
+
+// OpenEntry opens the content of entry with given name without extracting
+// it, entries added but not flushed yet can be opened as well unless they
+// are added by AddReader from a one-shot io.Reader. The tar stream is
+// scanned to the entry if it is compressed.
+func (tz *TzArchive) OpenEntry(name string) (io.ReadCloser, error) {
+	for i := len(tz.files) - 1; i >= 0; i-- {
+		f := tz.files[i]
+		if f.Name != name {
+			continue
+		} else if f.FileInfo().IsDir() {
+			return nil, &os.PathError{Op: "open", Path: name, Err: errors.New("is a directory")}
+		}
+		return tz.openFile(f)
+	}
+	return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+}
+
+// ReadFile reads the content of entry with given name without extracting
+// it, entries added but not flushed yet can be read as well.
+func (tz *TzArchive) ReadFile(name string) ([]byte, error) {
+	rc, err := tz.OpenEntry(name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
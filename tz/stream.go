@@ -16,7 +16,6 @@ package tz
 
 import (
 	"archive/tar"
-	"compress/gzip"
 	"io"
 	"os"
 	"path/filepath"
@@ -25,25 +24,42 @@ import (
 // A StreamArchive represents a streamable archive.
 type StreamArchive struct {
 	*tar.Writer
-	gw *gzip.Writer
+	cw          io.WriteCloser
+	w           io.Writer
+	compression string
+
+	// Options for packing entries.
+	PackOptions
 }
 
 func (s *StreamArchive) Close() (err error) {
-	if err = s.Writer.Close(); err != nil {
-		return err
-	}
-	return s.gw.Close()
+	return closeTar(s.Writer, s.cw, s.w, s.compression)
 }
 
 // NewStreamArachive returns a new streamable archive with given io.Writer.
 // It's caller's responsibility to close io.Writer and streamer after operation.
 func NewStreamArachive(w io.Writer) *StreamArchive {
-	s := &StreamArchive{}
-	s.gw = gzip.NewWriter(w)
-	s.Writer = tar.NewWriter(s.gw)
+	s, _ := NewStreamArchiveWithCompression(w, Gzip)
 	return s
 }
 
+// NewStreamArchiveWithCompression returns a new streamable archive with given
+// io.Writer and compression name, e.g. Tar for no compression.
+// It's caller's responsibility to close io.Writer and streamer after operation.
+func NewStreamArchiveWithCompression(w io.Writer, compression string) (*StreamArchive, error) {
+	cw, err := newCompressor(compression, w)
+	if err != nil {
+		return nil, err
+	}
+	return &StreamArchive{
+		Writer:      tar.NewWriter(cw),
+		cw:          cw,
+		w:           w,
+		compression: compression,
+		PackOptions: PackOptions{Compression: compression},
+	}, nil
+}
+
 // StreamFile streams a file or directory entry into StreamArchive.
 func (s *StreamArchive) StreamFile(relPath string, fi os.FileInfo, data []byte) error {
 	if fi.IsDir() {
@@ -52,6 +68,7 @@ func (s *StreamArchive) StreamFile(relPath string, fi os.FileInfo, data []byte)
 			return err
 		}
 		fh.Name = relPath + "/"
+		setOwner(fh, s.PackOptions)
 		if err = s.Writer.WriteHeader(fh); err != nil {
 			return err
 		}
@@ -66,6 +83,7 @@ func (s *StreamArchive) StreamFile(relPath string, fi os.FileInfo, data []byte)
 			return err
 		}
 		fh.Name = filepath.Join(relPath, fi.Name())
+		setOwner(fh, s.PackOptions)
 		if err = s.Writer.WriteHeader(fh); err != nil {
 			return err
 		}
@@ -84,6 +102,7 @@ func (s *StreamArchive) StreamReader(relPath string, fi os.FileInfo, r io.Reader
 		return err
 	}
 	fh.Name = filepath.Join(relPath, fi.Name())
+	setOwner(fh, s.PackOptions)
 	if err = s.Writer.WriteHeader(fh); err != nil {
 		return err
 	}
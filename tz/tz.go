@@ -13,16 +13,22 @@
 // under the License.
 
 // Package tz enables you to transparently read or write TAR.GZ compressed archives and the files inside them.
+// Plain TAR and other compressions like TAR.BZ2 are supported as well, see RegisterCompressor
+// and RegisterDecompressor for plugging in more compressions.
 package tz
 
 import (
 	"archive/tar"
+	"bytes"
 	"errors"
 	"io"
+	"io/fs"
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/unknwon/cae"
 )
@@ -31,6 +37,21 @@ import (
 type File struct {
 	*tar.Header
 	absPath string
+	oldName string      // Name before renaming, empty if not renamed.
+	h       *tar.Header // Original entry in archive, nil if added later.
+
+	// open opens the content of entry added by AddBytes or AddReader.
+	open func() (io.ReadCloser, error)
+	// isStream indicates the content can only be read once when flushing.
+	isStream bool
+}
+
+// rename changes name of an entry and records its original name.
+func (f *File) rename(name string) {
+	if len(f.oldName) == 0 {
+		f.oldName = f.Name
+	}
+	f.Name = name
 }
 
 // A TzArchive represents a file archive, compressed with Tar and Gzip.
@@ -40,15 +61,44 @@ type TzArchive struct {
 	NumFiles   int
 	Flag       int
 	Permission os.FileMode
+	// Compression is the name of compression used for flushing, e.g. Gzip.
+	// It is detected from the file when opening.
+	Compression string
+
+	// Options for extracting, the zero value is safe for untrusted archives.
+	cae.ExtractOptions
+	// PackOptions is used for packing when flushing changes, it is not
+	// embedded so that IDMap refers to the one of ExtractOptions.
+	PackOptions PackOptions
 
 	files        []*File
 	isHasChanged bool
+	view         cae.FS // Built on first call of View.
 
 	// For supporting flushing to io.Writer.
 	writer      io.Writer
 	isHasWriter bool
 }
 
+var _ cae.Archive = (*TzArchive)(nil)
+
+func init() {
+	cae.RegisterFormat(cae.Format{
+		Name: "tar",
+		Match: func(header []byte) bool {
+			_, ok := detectCompression(header)
+			return ok
+		},
+		Open: func(name string) (cae.Archive, error) {
+			tz, err := Open(name)
+			if err != nil {
+				return nil, err
+			}
+			return tz, nil
+		},
+	})
+}
+
 // OpenFile is the generalized open call; most users will use Open
 // instead. It opens the named tar.gz file with specified flag
 // (O_RDONLY etc.) if applicable. If successful,
@@ -61,7 +111,8 @@ func OpenFile(fileName string, flag int, perm os.FileMode) (*TzArchive, error) {
 }
 
 // Create creates the named tar.gz file, truncating
-// it if it already exists. If successful, methods on the returned
+// it if it already exists. The compression is chosen by file extension,
+// e.g. ".tar" for no compression, and defaults to Gzip. If successful, methods on the returned
 // TzArchive can be used for I/O; the associated file descriptor has mode
 // O_RDWR.
 // If there is an error, it will be of type *PathError.
@@ -82,6 +133,7 @@ func Open(fileName string) (*TzArchive, error) {
 // for write-only purpose operations.
 func New(w io.Writer) *TzArchive {
 	return &TzArchive{
+		Compression: Gzip,
 		writer:      w,
 		isHasWriter: true,
 	}
@@ -101,6 +153,75 @@ func (tz *TzArchive) List(prefixes ...string) []string {
 	return names
 }
 
+// ListMatch returns a string slice of files' name in TzArchive
+// which are matched by m.
+func (tz *TzArchive) ListMatch(m cae.Matcher) []string {
+	names := make([]string, 0, tz.NumFiles)
+	for _, f := range tz.files {
+		if cae.Matches(m, f.Name, false) {
+			names = append(names, f.Name)
+		}
+	}
+	return names
+}
+
+// entry returns metadata of an entry.
+func entry(f *File) cae.Entry {
+	e := cae.Entry{
+		Name:           f.Name,
+		Size:           f.Size,
+		CompressedSize: -1,
+		Mode:           f.FileInfo().Mode(),
+		ModTime:        f.ModTime,
+		Uid:            f.Uid,
+		Gid:            f.Gid,
+		Uname:          f.Uname,
+		Gname:          f.Gname,
+		LinkTarget:     f.Linkname,
+	}
+	switch {
+	case f.Typeflag == tar.TypeDir:
+		e.Type = cae.TypeDir
+	case f.Typeflag == tar.TypeSymlink:
+		e.Type = cae.TypeSymlink
+	case f.Typeflag == tar.TypeLink:
+		e.Type = cae.TypeHardlink
+	case e.Mode.IsRegular():
+		e.Type = cae.TypeFile
+	default:
+		e.Type = cae.TypeOther
+	}
+	return e
+}
+
+// ListEntries returns metadata of entries in TzArchive, including ones
+// not flushed yet. Only entries accepted by filter are returned if it
+// is not nil.
+func (tz *TzArchive) ListEntries(filter cae.EntryFilter) ([]cae.Entry, error) {
+	entries := make([]cae.Entry, 0, tz.NumFiles)
+	for _, f := range tz.files {
+		e := entry(f)
+		if filter != nil && !filter(e) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// Stat returns metadata of entry with given name, the trailing slash
+// of directory name can be omitted.
+func (tz *TzArchive) Stat(name string) (cae.Entry, error) {
+	for _, candidate := range []string{name, name + "/"} {
+		for i := len(tz.files) - 1; i >= 0; i-- {
+			if tz.files[i].Name == candidate {
+				return entry(tz.files[i]), nil
+			}
+		}
+	}
+	return cae.Entry{}, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+}
+
 // AddEmptyDir adds a raw directory entry to TzArchive,
 // it returns false if same directory enry already existed.
 func (tz *TzArchive) AddEmptyDir(dirPath string) bool {
@@ -121,7 +242,10 @@ func (tz *TzArchive) AddEmptyDir(dirPath string) bool {
 	}
 	tz.files = append(tz.files, &File{
 		Header: &tar.Header{
-			Name: dirPath + "/",
+			Typeflag: tar.TypeDir,
+			Name:     dirPath + "/",
+			Mode:     0755,
+			ModTime:  time.Now(),
 		},
 	})
 	tz.updateStat()
@@ -130,13 +254,22 @@ func (tz *TzArchive) AddEmptyDir(dirPath string) bool {
 
 // AddDir adds a directory and subdirectories entries to TzArchive.
 func (tz *TzArchive) AddDir(dirPath, absPath string) error {
+	return tz.addDir(dirPath, absPath, true)
+}
+
+// addDir adds entries of a directory and its subdirectories which are
+// matched by PackMatcher, the entry of directory itself is added if
+// isMatched is true.
+func (tz *TzArchive) addDir(dirPath, absPath string, isMatched bool) error {
 	dir, err := os.Open(absPath)
 	if err != nil {
 		return err
 	}
 	defer dir.Close()
 
-	tz.AddEmptyDir(dirPath)
+	if isMatched {
+		tz.AddEmptyDir(dirPath)
+	}
 
 	fis, err := dir.Readdir(0)
 	if err != nil {
@@ -146,10 +279,13 @@ func (tz *TzArchive) AddDir(dirPath, absPath string) error {
 		curPath := strings.Replace(absPath+"/"+fi.Name(), "\\", "/", -1)
 		tmpRecPath := strings.Replace(filepath.Join(dirPath, fi.Name()), "\\", "/", -1)
 		if fi.IsDir() {
-			if err = tz.AddDir(tmpRecPath, curPath); err != nil {
+			if cae.SkipDir(tz.PackOptions.PackMatcher, tmpRecPath) {
+				continue
+			}
+			if err = tz.addDir(tmpRecPath, curPath, cae.Matches(tz.PackOptions.PackMatcher, tmpRecPath, true)); err != nil {
 				return err
 			}
-		} else {
+		} else if cae.Matches(tz.PackOptions.PackMatcher, tmpRecPath, false) {
 			if err = tz.AddFile(tmpRecPath, curPath); err != nil {
 				return err
 			}
@@ -158,6 +294,41 @@ func (tz *TzArchive) AddDir(dirPath, absPath string) error {
 	return nil
 }
 
+// AddFS adds the file tree rooted at root of fsys to TzArchive under
+// dirPath, e.g. an embed.FS, use "" as dirPath to add entries at top
+// level. Contents of files are not read until changes are flushed.
+func (tz *TzArchive) AddFS(dirPath string, fsys fs.FS, root string) error {
+	dirPath = strings.Trim(dirPath, "/")
+	if len(dirPath) > 0 {
+		tz.AddEmptyDir(dirPath)
+	}
+
+	return cae.WalkFS(fsys, root, func(name, relPath string, fi fs.FileInfo) error {
+		recPath := path.Join(dirPath, relPath)
+		if fi.IsDir() && cae.SkipDir(tz.PackOptions.PackMatcher, recPath) {
+			return fs.SkipDir
+		} else if !cae.Matches(tz.PackOptions.PackMatcher, recPath, fi.IsDir()) {
+			return nil
+		}
+
+		h, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		file := &File{Header: h}
+		file.Name = recPath
+		if fi.IsDir() {
+			file.Name += "/"
+		} else {
+			file.open = func() (io.ReadCloser, error) {
+				return fsys.Open(name)
+			}
+		}
+		tz.addFile(file)
+		return nil
+	})
+}
+
 // updateStat should be called after every change for rebuilding statistic.
 func (tz *TzArchive) updateStat() {
 	tz.NumFiles = len(tz.files)
@@ -190,13 +361,21 @@ func (tz *TzArchive) AddFile(fileName, absPath string) error {
 	}
 	file.Name = fileName
 	file.absPath = absPath
+	tz.addFile(file)
+	return nil
+}
 
-	tz.AddEmptyDir(path.Dir(fileName))
+// addFile adds an entry to TzArchive, or replaces the one with same name.
+// Upper level directories are added automatically.
+func (tz *TzArchive) addFile(file *File) {
+	if dir := path.Dir(strings.TrimSuffix(file.Name, "/")); dir != "." {
+		tz.AddEmptyDir(dir)
+	}
 
 	isExist := false
-	for _, f := range tz.files {
-		if fileName == f.Name {
-			f = file
+	for i, f := range tz.files {
+		if file.Name == f.Name {
+			tz.files[i] = file
 			isExist = true
 			break
 		}
@@ -206,16 +385,179 @@ func (tz *TzArchive) AddFile(fileName, absPath string) error {
 	}
 
 	tz.updateStat()
+}
+
+// AddBytes adds a file entry to TzArchive with given content, data must
+// not be modified until changes are flushed.
+func (tz *TzArchive) AddBytes(name string, data []byte, opts cae.EntryOptions) error {
+	if len(name) == 0 || strings.HasSuffix(name, "/") {
+		return errors.New("invalid file name")
+	}
+
+	tz.addFile(&File{
+		Header: &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Mode:     int64(opts.Perm()),
+			Size:     int64(len(data)),
+			ModTime:  opts.Time(),
+		},
+		open: func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(data)), nil
+		},
+	})
+	return nil
+}
+
+// AddReader adds a file entry to TzArchive with content of size bytes read
+// from r, which is not read until changes are flushed. The entry can be
+// opened before flushing only if r implements io.ReaderAt and io.Seeker,
+// content is then read from the current offset of r.
+func (tz *TzArchive) AddReader(name string, r io.Reader, size int64, opts cae.EntryOptions) error {
+	if len(name) == 0 || strings.HasSuffix(name, "/") {
+		return errors.New("invalid file name")
+	} else if size < 0 {
+		return errors.New("negative size")
+	}
+
+	f := &File{
+		Header: &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Mode:     int64(opts.Perm()),
+			Size:     size,
+			ModTime:  opts.Time(),
+		},
+	}
+	if ra, ok := r.(interface {
+		io.ReaderAt
+		io.Seeker
+	}); ok {
+		// Content can be read repeatedly from the current offset.
+		offset, err := ra.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		f.open = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(io.NewSectionReader(ra, offset, size)), nil
+		}
+	} else {
+		f.open = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(r), nil
+		}
+		f.isStream = true
+	}
+	tz.addFile(f)
 	return nil
 }
 
+// RenameEntry renames an entry, directory entry is renamed without
+// its children, see MoveDir for moving a directory. Upper level
+// directories of new name are added automatically.
+func (tz *TzArchive) RenameEntry(oldName, newName string) error {
+	if strings.HasSuffix(oldName, "/") != strings.HasSuffix(newName, "/") {
+		return errors.New("cannot rename between file and directory")
+	}
+
+	var entry *File
+	idx := 0
+	for i, f := range tz.files {
+		if f.Name == newName {
+			return errors.New("entry with new name already exists")
+		} else if f.Name == oldName {
+			entry, idx = f, i
+		}
+	}
+	if entry == nil {
+		return errors.New("entry with given name not found")
+	}
+
+	entry.rename(newName)
+	tz.renameLinks(func(name string) (string, bool) {
+		return newName, name == cae.Clean(oldName)
+	})
+	n := len(tz.files)
+	if dir := path.Dir(strings.TrimSuffix(newName, "/")); dir != "." {
+		tz.AddEmptyDir(dir)
+	}
+	tz.insertBefore(idx, n)
+	tz.updateStat()
+	return nil
+}
+
+// MoveDir moves a directory and all its children to the new path.
+// Upper level directories of new path are added automatically.
+func (tz *TzArchive) MoveDir(oldPath, newPath string) error {
+	oldPath = strings.TrimSuffix(oldPath, "/") + "/"
+	newPath = strings.TrimSuffix(newPath, "/") + "/"
+	if oldPath == "/" || newPath == "/" {
+		return errors.New("invalid directory path")
+	} else if strings.HasPrefix(newPath, oldPath) {
+		return errors.New("cannot move directory into itself")
+	}
+
+	var entries []*File
+	idx := 0
+	for i, f := range tz.files {
+		if strings.HasPrefix(f.Name, oldPath) {
+			if len(entries) == 0 {
+				idx = i
+			}
+			entries = append(entries, f)
+		} else if strings.HasPrefix(f.Name, newPath) {
+			return errors.New("entry with new path already exists")
+		}
+	}
+	if len(entries) == 0 {
+		return errors.New("entry with given path not found")
+	}
+
+	for _, f := range entries {
+		f.rename(newPath + strings.TrimPrefix(f.Name, oldPath))
+	}
+	tz.renameLinks(func(name string) (string, bool) {
+		return newPath + strings.TrimPrefix(name, oldPath), strings.HasPrefix(name, oldPath)
+	})
+	n := len(tz.files)
+	tz.AddEmptyDir(newPath)
+	if dir := path.Dir(strings.TrimSuffix(newPath, "/")); dir != "." {
+		tz.AddEmptyDir(dir)
+	}
+	tz.insertBefore(idx, n)
+	tz.updateStat()
+	return nil
+}
+
+// insertBefore moves entries appended after the first n ones in front of
+// the entry with given index, so that upper level directories added for
+// renamed entries come before them.
+func (tz *TzArchive) insertBefore(idx, n int) {
+	added := append([]*File(nil), tz.files[n:]...)
+	copy(tz.files[idx+len(added):], tz.files[idx:n])
+	copy(tz.files[idx:], added)
+}
+
+// renameLinks updates targets of hard links after entries are renamed,
+// rename returns the new name of given target and if it is renamed.
+func (tz *TzArchive) renameLinks(rename func(name string) (string, bool)) {
+	for _, f := range tz.files {
+		if f.Typeflag != tar.TypeLink {
+			continue
+		}
+		if name, ok := rename(cae.Clean(strings.ReplaceAll(f.Linkname, "\\", "/"))); ok {
+			f.Linkname = name
+		}
+	}
+}
+
 // DeleteIndex deletes an entry in the archive by its index.
 func (tz *TzArchive) DeleteIndex(idx int) error {
-	if idx >= tz.NumFiles {
+	if idx < 0 || idx >= tz.NumFiles {
 		return errors.New("index out of range of number of files")
 	}
 
 	tz.files = append(tz.files[:idx], tz.files[idx+1:]...)
+	tz.updateStat()
 	return nil
 }
 
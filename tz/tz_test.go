@@ -15,15 +15,24 @@
 package tz
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
 	"sort"
 	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
+	"github.com/unknwon/cae"
 	"github.com/unknwon/com"
 )
 
@@ -157,7 +166,7 @@ func TestExtractTo(t *testing.T) {
 			list, err := com.StatDir(path.Join(os.TempDir(), "testdata/test2"), true)
 			So(err, ShouldBeNil)
 			So(com.CompareSliceStrU(list,
-				strings.Split("dir/ dir/bar readonly", " ")), ShouldBeTrue)
+				strings.Split("dir/ dir/bar dir/empty/ readonly", " ")), ShouldBeTrue)
 		})
 	})
 }
@@ -279,3 +288,852 @@ func TestDeleteName(t *testing.T) {
 		})
 	})
 }
+
+func TestFormat(t *testing.T) {
+	Convey("Open a tar.gz file by detecting its format", t, func() {
+		a, err := cae.Open("testdata/test.tar.gz")
+		So(err, ShouldBeNil)
+		So(a, ShouldHaveSameTypeAs, &TzArchive{})
+		So(a.Close(), ShouldBeNil)
+
+		Convey("Extract a tar.gz file by detecting its format", func() {
+			dest := path.Join(os.TempDir(), "testdata/test3")
+			os.RemoveAll(dest)
+			So(cae.ExtractTo("testdata/test.tar.gz", dest, "hello"), ShouldBeNil)
+			So(cae.IsExist(path.Join(dest, "hello")), ShouldBeTrue)
+		})
+	})
+}
+
+// writeTarGz writes a tar.gz file with given headers, regular files have
+// their names as content.
+func writeTarGz(name string, hdrs ...*tar.Header) error {
+	fw, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer fw.Close()
+
+	gw := gzip.NewWriter(fw)
+	tw := tar.NewWriter(gw)
+	for _, h := range hdrs {
+		if h.Typeflag == tar.TypeReg {
+			h.Size = int64(len(h.Name))
+		}
+		if err = tw.WriteHeader(h); err != nil {
+			return err
+		}
+		if h.Typeflag == tar.TypeReg {
+			if _, err = tw.Write([]byte(h.Name)); err != nil {
+				return err
+			}
+		}
+	}
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func TestExtractUnsafe(t *testing.T) {
+	Convey("Extract tar.gz files with unsafe entries", t, func() {
+		name := path.Join(os.TempDir(), "testdata/TestExtractUnsafe.tar.gz")
+		dest := path.Join(os.TempDir(), "testdata/TestExtractUnsafe")
+		os.RemoveAll(dest)
+
+		cases := []*tar.Header{
+			{Name: "../evil", Typeflag: tar.TypeReg, Mode: 0644},
+			{Name: "/evil", Typeflag: tar.TypeReg, Mode: 0644},
+			{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "../..", Mode: 0777},
+			{Name: "hard", Typeflag: tar.TypeLink, Linkname: "/etc/passwd", Mode: 0644},
+		}
+		for _, h := range cases {
+			So(writeTarGz(name, h), ShouldBeNil)
+			z, err := Open(name)
+			So(err, ShouldBeNil)
+
+			err = z.ExtractTo(dest)
+			So(err, ShouldHaveSameTypeAs, &cae.UnsafePathError{})
+			So(err.(*cae.UnsafePathError).Name, ShouldEqual, h.Name)
+			So(z.Close(), ShouldBeNil)
+		}
+
+		Convey("Extract with insecure mode", func() {
+			So(writeTarGz(name, &tar.Header{Name: "/evil", Typeflag: tar.TypeReg, Mode: 0644}), ShouldBeNil)
+			z, err := Open(name)
+			So(err, ShouldBeNil)
+			defer z.Close()
+			z.Insecure = true
+			So(z.ExtractTo(dest), ShouldBeNil)
+			So(cae.IsExist(path.Join(dest, "evil")), ShouldBeTrue)
+		})
+	})
+}
+
+func TestExtractLimits(t *testing.T) {
+	Convey("Extract a tar.gz file with limits", t, func() {
+		dest := path.Join(os.TempDir(), "testdata/TestExtractLimits")
+		os.RemoveAll(dest)
+		z, err := Open("testdata/test.tar.gz")
+		So(err, ShouldBeNil)
+
+		Convey("Exceed maximum number of entries", func() {
+			z.MaxEntries = 2
+			So(z.ExtractTo(dest), ShouldHaveSameTypeAs, &cae.LimitError{})
+		})
+
+		Convey("Exceed maximum total size", func() {
+			z.MaxTotalSize = 1
+			So(z.ExtractTo(dest), ShouldHaveSameTypeAs, &cae.LimitError{})
+		})
+
+		Convey("Within limits", func() {
+			z.MaxEntries = 5
+			z.MaxTotalSize = 1 << 20
+			So(z.ExtractTo(dest), ShouldBeNil)
+		})
+	})
+}
+
+func TestExtractSymlink(t *testing.T) {
+	Convey("Extract symbolic links from a tar.gz file", t, func() {
+		src := path.Join(os.TempDir(), "testdata/TestExtractSymlink")
+		dest := path.Join(os.TempDir(), "testdata/TestExtractSymlink.out")
+		name := path.Join(os.TempDir(), "testdata/TestExtractSymlink.tar.gz")
+		os.RemoveAll(src)
+		os.RemoveAll(dest)
+		So(os.MkdirAll(path.Join(src, "dir"), os.ModePerm), ShouldBeNil)
+		So(cae.Copy(path.Join(src, "dir/README.txt"), "testdata/README.txt"), ShouldBeNil)
+		So(os.Symlink("dir/README.txt", path.Join(src, "link")), ShouldBeNil)
+
+		Convey("Symbolic link inside destination", func() {
+			So(PackTo(src, name), ShouldBeNil)
+			So(ExtractTo(name, dest), ShouldBeNil)
+
+			target, err := os.Readlink(path.Join(dest, "link"))
+			So(err, ShouldBeNil)
+			So(target, ShouldEqual, "dir/README.txt")
+		})
+
+		Convey("Symbolic link outside destination", func() {
+			So(os.Symlink("../../../evil", path.Join(src, "dir/evil")), ShouldBeNil)
+			So(PackTo(src, name), ShouldBeNil)
+			So(ExtractTo(name, dest), ShouldHaveSameTypeAs, &cae.UnsafePathError{})
+		})
+	})
+}
+
+func TestExtractHardLink(t *testing.T) {
+	Convey("Extract hard links from a tar.gz file", t, func() {
+		name := path.Join(os.TempDir(), "testdata/TestExtractHardLink.tar.gz")
+		dest := path.Join(os.TempDir(), "testdata/TestExtractHardLink")
+		os.RemoveAll(dest)
+		So(writeTarGz(name,
+			&tar.Header{Name: "dir/foo", Typeflag: tar.TypeReg, Mode: 0644},
+			&tar.Header{Name: "link", Typeflag: tar.TypeLink, Linkname: "dir/foo"},
+		), ShouldBeNil)
+		z, err := Open(name)
+		So(err, ShouldBeNil)
+		defer z.Close()
+
+		Convey("Extract a hard link without its target", func() {
+			err := z.ExtractTo(dest, "link")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, `"dir/foo"`)
+		})
+
+		Convey("Extract a hard link along with its target", func() {
+			So(z.ExtractTo(dest, "dir/foo", "link"), ShouldBeNil)
+			p, err := ioutil.ReadFile(path.Join(dest, "link"))
+			So(err, ShouldBeNil)
+			So(string(p), ShouldEqual, "dir/foo")
+		})
+	})
+}
+
+func TestOwner(t *testing.T) {
+	Convey("Pack and extract tar.gz files with owners", t, func() {
+		name := path.Join(os.TempDir(), "testdata/TestOwner.tar.gz")
+
+		Convey("Pack with fixed owner", func() {
+			So(PackToWithOptions("testdata/testdir", name, PackOptions{
+				IDMap: cae.FixedOwner(0, 0),
+				Uname: "root",
+				Gname: "root",
+			}), ShouldBeNil)
+
+			z, err := Open(name)
+			So(err, ShouldBeNil)
+			for _, h := range z.File {
+				So(h.Uid, ShouldEqual, 0)
+				So(h.Gid, ShouldEqual, 0)
+				So(h.Uname, ShouldEqual, "root")
+				So(h.Gname, ShouldEqual, "root")
+			}
+		})
+
+		Convey("Pack with mapped owner", func() {
+			So(PackToWithOptions("testdata/testdir", name, PackOptions{
+				IDMap: func(uid, gid int) (int, int) {
+					return 1000, 1001
+				},
+			}), ShouldBeNil)
+
+			z, err := Open(name)
+			So(err, ShouldBeNil)
+			for _, h := range z.File {
+				So(h.Uid, ShouldEqual, 1000)
+				So(h.Gid, ShouldEqual, 1001)
+				So(h.Uname, ShouldBeEmpty)
+			}
+
+			Convey("Extract with remapped owner", func() {
+				dest := path.Join(os.TempDir(), "testdata/TestOwner")
+				os.RemoveAll(dest)
+				z.IDMap = func(uid, gid int) (int, int) {
+					So(uid, ShouldEqual, 1000)
+					So(gid, ShouldEqual, 1001)
+					return os.Getuid(), os.Getgid()
+				}
+				So(z.ExtractTo(dest), ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestCompression(t *testing.T) {
+	Convey("Read and write tar files with different compressions", t, func() {
+		Convey("Open a tar.bz2 file", func() {
+			z, err := Open("testdata/test.tar.bz2")
+			So(err, ShouldBeNil)
+			So(z.Compression, ShouldEqual, Bzip2)
+			So(com.CompareSliceStrU(z.List(),
+				strings.Split("dir/ dir/bar dir/empty/ hello readonly", " ")), ShouldBeTrue)
+
+			dest := path.Join(os.TempDir(), "testdata/TestCompression")
+			os.RemoveAll(dest)
+			So(z.ExtractTo(dest, "hello"), ShouldBeNil)
+			So(cae.IsExist(path.Join(dest, "hello")), ShouldBeTrue)
+		})
+
+		Convey("Pack and open a plain tar file", func() {
+			name := path.Join(os.TempDir(), "testdata/TestCompression.tar")
+			So(PackTo("testdata/testdir", name), ShouldBeNil)
+
+			z, err := Open(name)
+			So(err, ShouldBeNil)
+			So(z.Compression, ShouldEqual, Tar)
+			So(z.NumFiles, ShouldEqual, 3)
+
+			format, err := func() (string, error) {
+				f, err := os.Open(name)
+				if err != nil {
+					return "", err
+				}
+				defer f.Close()
+				return cae.DetectFormat(f)
+			}()
+			So(err, ShouldBeNil)
+			So(format, ShouldEqual, "tar")
+		})
+
+		Convey("Create a plain tar file", func() {
+			z, err := Create(path.Join(os.TempDir(), "testdata/TestCompression2.tar"))
+			So(err, ShouldBeNil)
+			So(z.Compression, ShouldEqual, Tar)
+		})
+
+		Convey("Open a tar file with unregistered compression", func() {
+			name := path.Join(os.TempDir(), "testdata/TestCompression.tar.zst")
+			So(ioutil.WriteFile(name, []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00}, 0644), ShouldBeNil)
+			_, err := Open(name)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "no decompressor registered for zstd")
+		})
+
+		Convey("Plug in compressor and decompressor", func() {
+			magic := []byte{0x04, 0x22, 0x4d, 0x18}
+			RegisterCompressor(Lz4, func(w io.Writer) (io.WriteCloser, error) {
+				if _, err := w.Write(magic); err != nil {
+					return nil, err
+				}
+				return gzip.NewWriter(w), nil
+			})
+			RegisterDecompressor(Lz4, func(r io.Reader) (io.ReadCloser, error) {
+				header := make([]byte, len(magic))
+				if _, err := io.ReadFull(r, header); err != nil {
+					return nil, err
+				} else if !bytes.Equal(header, magic) {
+					return nil, fmt.Errorf("invalid header %v", header)
+				}
+				return gzip.NewReader(r)
+			})
+
+			name := path.Join(os.TempDir(), "testdata/TestCompression.tar.lz4")
+			So(PackTo("testdata/testdir", name), ShouldBeNil)
+
+			z, err := Open(name)
+			So(err, ShouldBeNil)
+			So(z.Compression, ShouldEqual, Lz4)
+			So(z.NumFiles, ShouldEqual, 3)
+		})
+	})
+}
+
+// readEntries returns names and contents of regular file entries in archive.
+func readEntries(name string) (map[string]string, error) {
+	tr, rc, _, err := openFile(name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	entries := make(map[string]string)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		p, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		entries[h.Name] = string(p)
+	}
+	return entries, nil
+}
+
+func TestFlushAppend(t *testing.T) {
+	Convey("Decode trailer member of gzip archive", t, func() {
+		gr, err := gzip.NewReader(bytes.NewReader(gzipTrailer))
+		So(err, ShouldBeNil)
+		p, err := ioutil.ReadAll(gr)
+		So(err, ShouldBeNil)
+		So(p, ShouldResemble, make([]byte, endBlocksLen))
+	})
+
+	Convey("Append new entries to a gzip archive", t, func() {
+		dir := path.Join(os.TempDir(), "testdata/TestFlushAppend")
+		os.RemoveAll(dir)
+		So(os.MkdirAll(dir, os.ModePerm), ShouldBeNil)
+		name := path.Join(dir, "test.tar.gz")
+
+		tz, err := Create(name)
+		So(err, ShouldBeNil)
+		So(tz.AddFile("README.txt", "testdata/README.txt"), ShouldBeNil)
+		So(tz.Close(), ShouldBeNil)
+
+		before, err := ioutil.ReadFile(name)
+		So(err, ShouldBeNil)
+		So(bytes.HasSuffix(before, gzipTrailer), ShouldBeTrue)
+
+		tz, err = OpenFile(name, os.O_RDWR, 0)
+		So(err, ShouldBeNil)
+		So(tz.AddFile("dir/README.txt", "testdata/README.txt"), ShouldBeNil)
+		So(tz.Close(), ShouldBeNil)
+
+		// Existing members are copied as they are.
+		after, err := ioutil.ReadFile(name)
+		So(err, ShouldBeNil)
+		So(bytes.HasPrefix(after, before[:len(before)-len(gzipTrailer)]), ShouldBeTrue)
+		So(bytes.HasSuffix(after, gzipTrailer), ShouldBeTrue)
+
+		readme, err := ioutil.ReadFile("testdata/README.txt")
+		So(err, ShouldBeNil)
+		entries, err := readEntries(name)
+		So(err, ShouldBeNil)
+		So(entries, ShouldResemble, map[string]string{
+			"README.txt":     string(readme),
+			"dir/":           "",
+			"dir/README.txt": string(readme),
+		})
+
+		// No temporary file is left behind.
+		fis, err := ioutil.ReadDir(dir)
+		So(err, ShouldBeNil)
+		So(len(fis), ShouldEqual, 1)
+
+		Convey("Rewrite the archive after deletion", func() {
+			tz, err := OpenFile(name, os.O_RDWR, 0)
+			So(err, ShouldBeNil)
+			So(tz.DeleteName("README.txt"), ShouldBeNil)
+			So(tz.Close(), ShouldBeNil)
+
+			entries, err := readEntries(name)
+			So(err, ShouldBeNil)
+			So(entries, ShouldResemble, map[string]string{
+				"dir/":           "",
+				"dir/README.txt": string(readme),
+			})
+		})
+	})
+
+	Convey("Rewrite a gzip archive without trailer member", t, func() {
+		name := path.Join(os.TempDir(), "testdata/TestFlushAppend.tar.gz")
+		So(writeTarGz(name, &tar.Header{Name: "foo", Typeflag: tar.TypeReg, Mode: 0644}), ShouldBeNil)
+
+		tz, err := OpenFile(name, os.O_RDWR, 0)
+		So(err, ShouldBeNil)
+		So(tz.AddEmptyDir("bar"), ShouldBeTrue)
+		So(tz.Close(), ShouldBeNil)
+
+		entries, err := readEntries(name)
+		So(err, ShouldBeNil)
+		So(entries, ShouldResemble, map[string]string{"foo": "foo", "bar/": ""})
+
+		p, err := ioutil.ReadFile(name)
+		So(err, ShouldBeNil)
+		So(bytes.HasSuffix(p, gzipTrailer), ShouldBeTrue)
+	})
+}
+
+func TestRenameEntry(t *testing.T) {
+	Convey("Rename and move entries in archive", t, func() {
+		name := path.Join(os.TempDir(), "testdata/TestRenameEntry.tar.gz")
+		tz, err := Create(name)
+		So(err, ShouldBeNil)
+		So(tz.AddFile("README.txt", "testdata/README.txt"), ShouldBeNil)
+		So(tz.AddFile("dir/README.txt", "testdata/README.txt"), ShouldBeNil)
+		So(tz.AddFile("dir/sub/README.txt", "testdata/README.txt"), ShouldBeNil)
+		So(tz.Close(), ShouldBeNil)
+
+		tz, err = OpenFile(name, os.O_RDWR, 0)
+		So(err, ShouldBeNil)
+
+		Convey("Rename with invalid arguments", func() {
+			So(tz.RenameEntry("README.txt", "dir/"), ShouldNotBeNil)
+			So(tz.RenameEntry("README.txt", "dir/README.txt"), ShouldNotBeNil)
+			So(tz.RenameEntry("404.txt", "405.txt"), ShouldNotBeNil)
+			So(tz.MoveDir("dir", "dir/sub/dir"), ShouldNotBeNil)
+			So(tz.MoveDir("dir/sub", "dir"), ShouldNotBeNil)
+			So(tz.MoveDir("404", "405"), ShouldNotBeNil)
+			So(tz.MoveDir("dir", ""), ShouldNotBeNil)
+			So(tz.Close(), ShouldBeNil)
+		})
+
+		Convey("Rename and move then flush", func() {
+			So(tz.RenameEntry("README.txt", "doc/README.md"), ShouldBeNil)
+			So(tz.MoveDir("dir", "a/b"), ShouldBeNil)
+			So(tz.List(), ShouldResemble, []string{
+				"doc/", "doc/README.md", "a/", "a/b/", "a/b/README.txt",
+				"a/b/sub/", "a/b/sub/README.txt",
+			})
+			So(tz.Close(), ShouldBeNil)
+
+			readme, err := ioutil.ReadFile("testdata/README.txt")
+			So(err, ShouldBeNil)
+			entries, err := readEntries(name)
+			So(err, ShouldBeNil)
+			So(entries, ShouldResemble, map[string]string{
+				"doc/":               "",
+				"doc/README.md":      string(readme),
+				"a/":                 "",
+				"a/b/":               "",
+				"a/b/README.txt":     string(readme),
+				"a/b/sub/":           "",
+				"a/b/sub/README.txt": string(readme),
+			})
+		})
+	})
+
+	Convey("Rename targets of hard links", t, func() {
+		name := path.Join(os.TempDir(), "testdata/TestRenameEntryLink.tar.gz")
+		So(writeTarGz(name,
+			&tar.Header{Name: "dir/foo", Typeflag: tar.TypeReg, Mode: 0644},
+			&tar.Header{Name: "link", Typeflag: tar.TypeLink, Linkname: "dir/foo"},
+		), ShouldBeNil)
+
+		tz, err := OpenFile(name, os.O_RDWR, 0)
+		So(err, ShouldBeNil)
+		So(tz.MoveDir("dir", "new"), ShouldBeNil)
+		So(tz.Close(), ShouldBeNil)
+
+		tz, err = Open(name)
+		So(err, ShouldBeNil)
+		defer tz.Close()
+		So(tz.List(), ShouldResemble, []string{"new/", "new/foo", "link"})
+		So(tz.File[2].Linkname, ShouldEqual, "new/foo")
+	})
+}
+
+func TestAddBytes(t *testing.T) {
+	Convey("Add entries from memory and io.Reader", t, func() {
+		name := path.Join(os.TempDir(), "testdata/TestAddBytes.tar.gz")
+		tz, err := Create(name)
+		So(err, ShouldBeNil)
+
+		So(tz.AddBytes("dir/", nil, cae.EntryOptions{}), ShouldNotBeNil)
+		So(tz.AddReader("foo", strings.NewReader("foo"), -1, cae.EntryOptions{}), ShouldNotBeNil)
+
+		mtime := time.Date(2020, 2, 2, 10, 20, 30, 0, time.UTC)
+		So(tz.AddBytes("conf/app.ini", []byte("RUN_MODE = prod\n"), cae.EntryOptions{
+			Mode:    0600,
+			ModTime: mtime,
+		}), ShouldBeNil)
+		So(tz.AddReader("MANIFEST", strings.NewReader("name: cae\n"), 10, cae.EntryOptions{}), ShouldBeNil)
+		So(tz.List(), ShouldResemble, []string{"conf/", "conf/app.ini", "MANIFEST"})
+		So(tz.Close(), ShouldBeNil)
+
+		tz, err = Open(name)
+		So(err, ShouldBeNil)
+		defer tz.Close()
+		So(len(tz.File), ShouldEqual, 3)
+		So(tz.File[1].Mode, ShouldEqual, 0600)
+		So(tz.File[1].ModTime.Unix(), ShouldEqual, mtime.Unix())
+		So(tz.File[2].Mode, ShouldEqual, 0644)
+
+		entries, err := readEntries(name)
+		So(err, ShouldBeNil)
+		So(entries, ShouldResemble, map[string]string{
+			"conf/":        "",
+			"conf/app.ini": "RUN_MODE = prod\n",
+			"MANIFEST":     "name: cae\n",
+		})
+
+		Convey("Flush with short content of reader", func() {
+			tz, err := OpenFile(name, os.O_RDWR, 0)
+			So(err, ShouldBeNil)
+			defer tz.Close()
+			So(tz.AddReader("short", strings.NewReader("short"), 10, cae.EntryOptions{}), ShouldBeNil)
+			So(tz.Flush(), ShouldEqual, io.ErrUnexpectedEOF)
+		})
+	})
+}
+
+func TestView(t *testing.T) {
+	Convey("View entries of archive as a file system", t, func() {
+		for _, ext := range []string{".tar.gz", ".tar"} {
+			name := path.Join(os.TempDir(), "testdata/TestView"+ext)
+			fw, err := os.Create(name)
+			So(err, ShouldBeNil)
+			cw, err := newCompressor(compressionByExt(name), fw)
+			So(err, ShouldBeNil)
+			tw := tar.NewWriter(cw)
+			for _, name := range []string{"a/b/c.txt", "d.txt", "a/e.txt"} {
+				So(tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(name))}), ShouldBeNil)
+				_, err = tw.Write([]byte(name))
+				So(err, ShouldBeNil)
+			}
+			So(tw.WriteHeader(&tar.Header{Name: "a/link.txt", Typeflag: tar.TypeLink, Linkname: "d.txt"}), ShouldBeNil)
+			So(tw.Close(), ShouldBeNil)
+			So(cw.Close(), ShouldBeNil)
+			So(fw.Close(), ShouldBeNil)
+
+			tz, err := Open(name)
+			So(err, ShouldBeNil)
+
+			fsys := tz.View()
+			So(fstest.TestFS(fsys, "a/b/c.txt", "d.txt", "a/e.txt", "a/link.txt"), ShouldBeNil)
+
+			// Upper level directories are synthesized.
+			fi, err := fsys.Stat("a/b")
+			So(err, ShouldBeNil)
+			So(fi.IsDir(), ShouldBeTrue)
+
+			for _, name := range []string{"a/b/c.txt", "d.txt", "a/e.txt"} {
+				p, err := fsys.ReadFile(name)
+				So(err, ShouldBeNil)
+				So(string(p), ShouldEqual, name)
+			}
+			p, err := fsys.ReadFile("a/link.txt")
+			So(err, ShouldBeNil)
+			So(string(p), ShouldEqual, "d.txt")
+			So(tz.Close(), ShouldBeNil)
+		}
+	})
+}
+
+func TestPackFS(t *testing.T) {
+	mtime := time.Date(2020, 2, 2, 10, 20, 30, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"README.md":          {Data: []byte("# cae\n"), Mode: 0644, ModTime: mtime},
+		"static/index.html":  {Data: []byte("<html></html>\n"), Mode: 0644, ModTime: mtime},
+		"static/css/app.css": {Data: []byte("body {}\n"), Mode: 0600, ModTime: mtime},
+		"static/.DS_Store":   {Data: []byte("junk")},
+	}
+
+	Convey("Pack files from fs.FS", t, func() {
+		name := path.Join(os.TempDir(), "testdata/TestPackFS.tar.gz")
+		fw, err := os.Create(name)
+		So(err, ShouldBeNil)
+		So(PackFS(fsys, "static", fw, PackOptions{}), ShouldBeNil)
+		So(fw.Close(), ShouldBeNil)
+
+		tz, err := Open(name)
+		So(err, ShouldBeNil)
+		defer tz.Close()
+		So(tz.List(), ShouldResemble, []string{"css/", "css/app.css", "index.html"})
+		So(tz.File[1].Mode, ShouldEqual, 0600)
+		So(tz.File[1].ModTime.Unix(), ShouldEqual, mtime.Unix())
+
+		p, err := fs.ReadFile(tz.View(), "css/app.css")
+		So(err, ShouldBeNil)
+		So(string(p), ShouldEqual, "body {}\n")
+	})
+
+	Convey("Pack files from fs.FS with compression", t, func() {
+		name := path.Join(os.TempDir(), "testdata/TestPackFS.tar")
+		fw, err := os.Create(name)
+		So(err, ShouldBeNil)
+		So(PackFS(fsys, "static", fw, PackOptions{Compression: Tar}), ShouldBeNil)
+		So(fw.Close(), ShouldBeNil)
+
+		tz, err := Open(name)
+		So(err, ShouldBeNil)
+		defer tz.Close()
+		So(tz.Compression, ShouldEqual, Tar)
+		So(tz.List(), ShouldResemble, []string{"css/", "css/app.css", "index.html"})
+	})
+
+	Convey("Add files from fs.FS to archive", t, func() {
+		name := path.Join(os.TempDir(), "testdata/TestAddFS.tar.gz")
+		tz, err := Create(name)
+		So(err, ShouldBeNil)
+		So(tz.AddFS("assets", fsys, "."), ShouldBeNil)
+		So(tz.List(), ShouldResemble, []string{
+			"assets/", "assets/README.md", "assets/static/", "assets/static/css/",
+			"assets/static/css/app.css", "assets/static/index.html",
+		})
+		So(tz.Close(), ShouldBeNil)
+
+		tz, err = Open(name)
+		So(err, ShouldBeNil)
+		defer tz.Close()
+		So(fstest.TestFS(tz.View(), "assets/README.md", "assets/static/css/app.css", "assets/static/index.html"), ShouldBeNil)
+		p, err := fs.ReadFile(tz.View(), "assets/static/index.html")
+		So(err, ShouldBeNil)
+		So(string(p), ShouldEqual, "<html></html>\n")
+	})
+}
+
+func TestOpenEntry(t *testing.T) {
+	Convey("Open entries without extracting", t, func() {
+		name := path.Join(os.TempDir(), "testdata/TestOpenEntry.tar.gz")
+		So(writeTarGz(name,
+			&tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755},
+			&tar.Header{Name: "dir/foo", Typeflag: tar.TypeReg, Mode: 0644},
+			&tar.Header{Name: "bar", Typeflag: tar.TypeReg, Mode: 0644},
+			&tar.Header{Name: "link", Typeflag: tar.TypeLink, Linkname: "dir/foo"},
+		), ShouldBeNil)
+		tz, err := OpenFile(name, os.O_RDWR, 0)
+		So(err, ShouldBeNil)
+		defer tz.Close()
+
+		p, err := tz.ReadFile("bar")
+		So(err, ShouldBeNil)
+		So(string(p), ShouldEqual, "bar")
+		p, err = tz.ReadFile("link")
+		So(err, ShouldBeNil)
+		So(string(p), ShouldEqual, "dir/foo")
+
+		_, err = tz.ReadFile("dir/")
+		So(err, ShouldNotBeNil)
+		_, err = tz.ReadFile("404")
+		So(os.IsNotExist(err), ShouldBeTrue)
+
+		Convey("Open entries not flushed yet", func() {
+			readme, err := ioutil.ReadFile("testdata/README.txt")
+			So(err, ShouldBeNil)
+			So(tz.AddFile("README.txt", "testdata/README.txt"), ShouldBeNil)
+			So(tz.AddBytes("manifest.json", []byte("{}"), cae.EntryOptions{}), ShouldBeNil)
+			So(tz.RenameEntry("dir/foo", "foo"), ShouldBeNil)
+
+			p, err := tz.ReadFile("README.txt")
+			So(err, ShouldBeNil)
+			So(string(p), ShouldEqual, string(readme))
+
+			rc, err := tz.OpenEntry("manifest.json")
+			So(err, ShouldBeNil)
+			p, err = ioutil.ReadAll(rc)
+			So(err, ShouldBeNil)
+			So(rc.Close(), ShouldBeNil)
+			So(string(p), ShouldEqual, "{}")
+
+			p, err = tz.ReadFile("foo")
+			So(err, ShouldBeNil)
+			So(string(p), ShouldEqual, "dir/foo")
+		})
+
+		Convey("Open entries added from io.Reader", func() {
+			r := strings.NewReader("--seekable")
+			_, err := r.Seek(2, io.SeekStart)
+			So(err, ShouldBeNil)
+			So(tz.AddReader("seekable", r, 8, cae.EntryOptions{}), ShouldBeNil)
+			So(tz.AddReader("stream", io.MultiReader(strings.NewReader("stream")), 6, cae.EntryOptions{}), ShouldBeNil)
+
+			for i := 0; i < 2; i++ {
+				p, err := tz.ReadFile("seekable")
+				So(err, ShouldBeNil)
+				So(string(p), ShouldEqual, "seekable")
+			}
+			_, err = tz.OpenEntry("stream")
+			So(err, ShouldNotBeNil)
+
+			So(tz.Flush(), ShouldBeNil)
+			p, err := tz.ReadFile("seekable")
+			So(err, ShouldBeNil)
+			So(string(p), ShouldEqual, "seekable")
+			p, err = tz.ReadFile("stream")
+			So(err, ShouldBeNil)
+			So(string(p), ShouldEqual, "stream")
+		})
+	})
+
+	Convey("Decompress archive only once for reading contents", t, func() {
+		gunzip := findCompression(Gzip).decompressor
+		defer RegisterDecompressor(Gzip, gunzip)
+		opens := 0
+		RegisterDecompressor(Gzip, func(r io.Reader) (io.ReadCloser, error) {
+			opens++
+			return gunzip(r)
+		})
+
+		name := path.Join(os.TempDir(), "testdata/TestOpenEntryOnce.tar.gz")
+		So(writeTarGz(name,
+			&tar.Header{Name: "foo", Typeflag: tar.TypeReg, Mode: 0644},
+			&tar.Header{Name: "bar", Typeflag: tar.TypeReg, Mode: 0644},
+			&tar.Header{Name: "link", Typeflag: tar.TypeLink, Linkname: "foo"},
+		), ShouldBeNil)
+		tz, err := Open(name)
+		So(err, ShouldBeNil)
+		So(opens, ShouldEqual, 1)
+
+		for i := 0; i < 3; i++ {
+			for _, name := range []string{"foo", "bar", "link"} {
+				_, err := fs.ReadFile(tz.View(), name)
+				So(err, ShouldBeNil)
+			}
+		}
+		p, err := fs.ReadFile(tz.View(), "link")
+		So(err, ShouldBeNil)
+		So(string(p), ShouldEqual, "foo")
+		So(opens, ShouldEqual, 2)
+
+		spill := tz.ReadCloser.spill.Name()
+		So(tz.Close(), ShouldBeNil)
+		_, err = os.Stat(spill)
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+}
+
+func TestListEntries(t *testing.T) {
+	Convey("List metadata of entries", t, func() {
+		name := path.Join(os.TempDir(), "testdata/TestListEntries.tar.gz")
+		mtime := time.Date(2020, 2, 2, 10, 20, 30, 0, time.UTC)
+		So(writeTarGz(name,
+			&tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755},
+			&tar.Header{Name: "dir/foo", Typeflag: tar.TypeReg, Mode: 0640, ModTime: mtime, Uid: 1000, Uname: "unknwon"},
+			&tar.Header{Name: "symlink", Typeflag: tar.TypeSymlink, Linkname: "dir/foo", Mode: 0777},
+			&tar.Header{Name: "hardlink", Typeflag: tar.TypeLink, Linkname: "dir/foo"},
+		), ShouldBeNil)
+		tz, err := OpenFile(name, os.O_RDWR, 0)
+		So(err, ShouldBeNil)
+		defer tz.Close()
+
+		e, err := tz.Stat("dir/foo")
+		So(err, ShouldBeNil)
+		So(e.Type, ShouldEqual, cae.TypeFile)
+		So(e.Size, ShouldEqual, 7)
+		So(e.CompressedSize, ShouldEqual, -1)
+		So(e.Mode, ShouldEqual, 0640)
+		So(e.ModTime.Unix(), ShouldEqual, mtime.Unix())
+		So(e.Uid, ShouldEqual, 1000)
+		So(e.Uname, ShouldEqual, "unknwon")
+
+		e, err = tz.Stat("dir")
+		So(err, ShouldBeNil)
+		So(e.Type, ShouldEqual, cae.TypeDir)
+
+		e, err = tz.Stat("symlink")
+		So(err, ShouldBeNil)
+		So(e.Type, ShouldEqual, cae.TypeSymlink)
+		So(e.LinkTarget, ShouldEqual, "dir/foo")
+
+		_, err = tz.Stat("404")
+		So(os.IsNotExist(err), ShouldBeTrue)
+
+		entries, err := tz.ListEntries(func(e cae.Entry) bool {
+			return e.Type == cae.TypeHardlink
+		})
+		So(err, ShouldBeNil)
+		So(len(entries), ShouldEqual, 1)
+		So(entries[0].Name, ShouldEqual, "hardlink")
+		So(entries[0].LinkTarget, ShouldEqual, "dir/foo")
+
+		Convey("List entries not flushed yet", func() {
+			So(tz.AddBytes("manifest.json", []byte("{}"), cae.EntryOptions{Mode: 0600}), ShouldBeNil)
+			e, err := tz.Stat("manifest.json")
+			So(err, ShouldBeNil)
+			So(e.Size, ShouldEqual, 2)
+			So(e.Mode, ShouldEqual, 0600)
+
+			entries, err := tz.ListEntries(nil)
+			So(err, ShouldBeNil)
+			So(len(entries), ShouldEqual, 5)
+		})
+	})
+}
+
+func TestMatcher(t *testing.T) {
+	Convey("Select entries by matchers", t, func() {
+		name := path.Join(os.TempDir(), "testdata/TestMatcher.tar.gz")
+		So(com.Copy("testdata/test.tar.gz", name), ShouldBeNil)
+		tz, err := OpenFile(name, os.O_RDWR, 0)
+		So(err, ShouldBeNil)
+		defer tz.Close()
+
+		m, err := cae.Glob("**/bar", "hello")
+		So(err, ShouldBeNil)
+		So(tz.ListMatch(m), ShouldResemble, []string{"hello", "dir/bar"})
+
+		Convey("Extract entries by matcher", func() {
+			dest := path.Join(os.TempDir(), "testdata/TestMatcher")
+			os.RemoveAll(dest)
+			include, err := cae.Glob("dir/**")
+			So(err, ShouldBeNil)
+			exclude, err := cae.Gitignore("bar")
+			So(err, ShouldBeNil)
+			tz.ExtractMatcher = cae.Select(include, exclude)
+			So(tz.ExtractTo(dest), ShouldBeNil)
+
+			list, err := com.StatDir(dest, true)
+			So(err, ShouldBeNil)
+			So(list, ShouldResemble, []string{"dir/", "dir/empty/"})
+		})
+	})
+
+	Convey("Pack files by matcher", t, func() {
+		src := path.Join(os.TempDir(), "testdata/TestMatcherSrc")
+		os.RemoveAll(src)
+		So(os.MkdirAll(path.Join(src, "node_modules/pkg"), os.ModePerm), ShouldBeNil)
+		So(os.MkdirAll(path.Join(src, "cmd"), os.ModePerm), ShouldBeNil)
+		for _, name := range []string{"main.go", "cmd/main.go", "node_modules/pkg/index.js", "README.md"} {
+			So(ioutil.WriteFile(path.Join(src, name), []byte(name), 0644), ShouldBeNil)
+		}
+		exclude, err := cae.Gitignore("node_modules/", "*.md")
+		So(err, ShouldBeNil)
+		m := cae.Select(nil, exclude)
+
+		name := path.Join(os.TempDir(), "testdata/TestMatcherPack.tar.gz")
+		So(PackToWithOptions(src, name, PackOptions{PackMatcher: m}), ShouldBeNil)
+		tz, err := Open(name)
+		So(err, ShouldBeNil)
+		defer tz.Close()
+		list := tz.List()
+		sort.Strings(list)
+		So(list, ShouldResemble, []string{"cmd/", "cmd/main.go", "main.go"})
+
+		Convey("Add directory by matcher", func() {
+			tz, err := Create(path.Join(os.TempDir(), "testdata/TestMatcherAdd.tar.gz"))
+			So(err, ShouldBeNil)
+			defer tz.Close()
+			tz.PackOptions.PackMatcher = m
+			So(tz.AddDir("src", src), ShouldBeNil)
+			list := tz.List()
+			sort.Strings(list)
+			So(list, ShouldResemble, []string{"src/", "src/cmd/", "src/cmd/main.go", "src/main.go"})
+		})
+	})
+}
@@ -16,9 +16,12 @@ package tz
 
 import (
 	"archive/tar"
-	"compress/gzip"
+	"bytes"
 	"fmt"
 	"io"
+	"io/fs"
+	"io/ioutil"
+	"math"
 	"os"
 	"path"
 	"path/filepath"
@@ -30,25 +33,24 @@ import (
 // Switcher of printing trace information when pack and extract.
 var Verbose = true
 
-// extractFile extracts zip.File to file system.
-func extractFile(f *tar.Header, tr *tar.Reader, destPath string) error {
-	filePath := path.Join(destPath, f.Name)
+// extractFile extracts tar.Header to given path of file system.
+func extractFile(f *tar.Header, tr *tar.Reader, filePath string, l *cae.Limiter) error {
 	os.MkdirAll(path.Dir(filePath), os.ModePerm)
 
+	if f.Typeflag == tar.TypeSymlink {
+		return cae.Symlink(f.Linkname, filePath)
+	}
+
 	fw, err := os.Create(filePath)
 	if err != nil {
 		return err
 	}
 	defer fw.Close()
 
-	if _, err = io.Copy(fw, tr); err != nil {
+	if _, err = io.Copy(fw, l.Reader(f.Name, tr)); err != nil {
 		return err
 	}
 
-	// Skip symbolic links.
-	if f.FileInfo().Mode()&os.ModeSymlink != 0 {
-		return nil
-	}
 	// Set back file information.
 	if err = os.Chtimes(filePath, f.FileInfo().ModTime(), f.FileInfo().ModTime()); err != nil {
 		return err
@@ -56,6 +58,39 @@ func extractFile(f *tar.Header, tr *tar.Reader, destPath string) error {
 	return os.Chmod(filePath, f.FileInfo().Mode())
 }
 
+// removeFile removes existing file or symbolic link at given path.
+func removeFile(filePath string) error {
+	if fi, err := os.Lstat(filePath); err == nil && !fi.IsDir() {
+		return os.Remove(filePath)
+	}
+	return nil
+}
+
+// extractLink creates hard link from tar.Header to given path of file system,
+// the link target must be extracted already.
+func extractLink(h *tar.Header, destPath, filePath string) error {
+	target := path.Join(destPath, cae.Clean(strings.ReplaceAll(h.Linkname, "\\", "/")))
+	if _, err := os.Lstat(target); os.IsNotExist(err) {
+		return fmt.Errorf("tz: target %q of hard link %q is not extracted", h.Linkname, h.Name)
+	}
+
+	os.MkdirAll(path.Dir(filePath), os.ModePerm)
+	if err := removeFile(filePath); err != nil {
+		return err
+	}
+	return os.Link(target, filePath)
+}
+
+// extractNode creates FIFO or device file from tar.Header to given path
+// of file system.
+func extractNode(h *tar.Header, filePath string) error {
+	os.MkdirAll(path.Dir(filePath), os.ModePerm)
+	if err := removeFile(filePath); err != nil {
+		return err
+	}
+	return mknod(h, filePath)
+}
+
 var defaultExtractFunc = func(fullName string, fi os.FileInfo) error {
 	if !Verbose {
 		return nil
@@ -70,7 +105,7 @@ var defaultExtractFunc = func(fullName string, fi os.FileInfo) error {
 // It accepts a function as a middleware for custom operations.
 func (tz *TzArchive) ExtractToFunc(destPath string, fn cae.HookFunc, entries ...string) (err error) {
 	destPath = strings.ReplaceAll(destPath, "\\", "/")
-	isHasEntry := len(entries) > 0
+	m := cae.EntryMatcher(tz.ExtractOptions, entries...)
 	if Verbose {
 		fmt.Println("Extracting " + tz.FileName + "...")
 	}
@@ -80,21 +115,35 @@ func (tz *TzArchive) ExtractToFunc(destPath string, fn cae.HookFunc, entries ...
 	for _, f := range tz.files {
 		if !cae.IsExist(f.absPath) {
 			continue
+		} else if !cae.Matches(m, f.Name, false) {
+			continue
 		}
 
 		relPath := path.Join(destPath, f.Name)
+		if !tz.Insecure {
+			if relPath, err = cae.SecureJoin(destPath, f.Name); err != nil {
+				return err
+			}
+		}
 		os.MkdirAll(path.Dir(relPath), os.ModePerm)
 		if err := cae.Copy(relPath, f.absPath); err != nil {
 			return err
 		}
 	}
 
-	tr, f, err := openFile(tz.FileName)
+	f, err := os.Open(tz.FileName)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
+	l := cae.NewLimiter(tz.ExtractOptions)
+	tr, rc, _, err := newReader(l.CompressedReader(f))
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
 	for {
 		h, err := tr.Next()
 		if err == io.EOF {
@@ -103,43 +152,61 @@ func (tz *TzArchive) ExtractToFunc(destPath string, fn cae.HookFunc, entries ...
 			return err
 		}
 
-		h.Name = cae.Clean(strings.ReplaceAll(h.Name, "\\", "/"))
+		name := cae.Clean(strings.ReplaceAll(h.Name, "\\", "/"))
+		if !cae.Matches(m, name, h.Typeflag == tar.TypeDir) {
+			continue
+		}
 
-		// Directory.
-		if h.Typeflag == tar.TypeDir {
-			if isHasEntry {
-				if cae.IsEntry(h.Name, entries) {
-					if err = fn(h.Name, h.FileInfo()); err != nil {
-						continue
-					}
-					os.MkdirAll(path.Join(destPath, h.Name), os.ModePerm)
-				}
-				continue
+		filePath := path.Join(destPath, name)
+		if !tz.Insecure {
+			if filePath, err = cae.SecureJoin(destPath, h.Name); err != nil {
+				return err
 			}
-			if err = fn(h.Name, h.FileInfo()); err != nil {
-				continue
+			switch h.Typeflag {
+			case tar.TypeSymlink:
+				err = cae.CheckSymlink(destPath, h.Name, h.Linkname)
+			case tar.TypeLink:
+				err = cae.CheckHardlink(destPath, h.Name, h.Linkname)
 			}
-			os.MkdirAll(path.Join(destPath, h.Name), os.ModePerm)
+			if err != nil {
+				return err
+			}
+		}
+		h.Name = name
+
+		if err = fn(h.Name, h.FileInfo()); err != nil {
 			continue
+		} else if err = l.Entry(h.Name); err != nil {
+			return err
 		}
 
-		// File.
-		if isHasEntry {
-			if cae.IsEntry(h.Name, entries) {
-				if err = fn(h.Name, h.FileInfo()); err != nil {
-					continue
-				}
-				err = extractFile(h, tr, destPath)
-			}
-		} else {
-			if err = fn(h.Name, h.FileInfo()); err != nil {
+		switch h.Typeflag {
+		case tar.TypeDir:
+			os.MkdirAll(filePath, os.ModePerm)
+		case tar.TypeLink:
+			err = extractLink(h, destPath, filePath)
+		case tar.TypeFifo:
+			err = extractNode(h, filePath)
+		case tar.TypeChar, tar.TypeBlock:
+			if !tz.Devices {
 				continue
 			}
-			err = extractFile(h, tr, destPath)
+			err = extractNode(h, filePath)
+		default:
+			err = extractFile(h, tr, filePath, l)
 		}
 		if err != nil {
 			return err
 		}
+
+		if err = cae.Chown(tz.ExtractOptions, filePath, h.Uid, h.Gid); err != nil {
+			return err
+		} else if mode := h.FileInfo().Mode(); mode&(os.ModeSetuid|os.ModeSetgid) != 0 {
+			// Changing owner may clear setuid and setgid bits.
+			if err = os.Chmod(filePath, mode); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
@@ -151,6 +218,12 @@ func (tz *TzArchive) ExtractTo(destPath string, entries ...string) (err error) {
 	return tz.ExtractToFunc(destPath, defaultExtractFunc, entries...)
 }
 
+// SetExtractOptions replaces options for extracting, e.g. limits against
+// decompression bombs, of TzArchive.
+func (tz *TzArchive) SetExtractOptions(opts cae.ExtractOptions) {
+	tz.ExtractOptions = opts
+}
+
 // ExtractTo extracts given archive or the given files to the
 // specified destination.
 func ExtractTo(srcPath, destPath string, entries ...string) (err error) {
@@ -162,104 +235,213 @@ func ExtractTo(srcPath, destPath string, entries ...string) (err error) {
 	return tz.ExtractToFunc(destPath, defaultExtractFunc, entries...)
 }
 
-// extractFile extracts file from TzArchive to file system.
-func (tz *TzArchive) extractFile(f *File, tr *tar.Reader) error {
-	if !tz.isHasWriter {
-		for _, h := range tz.ReadCloser.File {
-			if f.Name == h.Name {
-				return extractFile(h, tr, f.absPath)
-			}
+// writeFile writes an entry added later to tar.Writer.
+func writeFile(tw *tar.Writer, f *File, ps *packState) error {
+	// File added by AddBytes or AddReader.
+	if f.open != nil {
+		r, err := f.open()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		h := *f.Header
+		setOwner(&h, ps.opts)
+		if err = tw.WriteHeader(&h); err != nil {
+			return err
 		}
+		if _, err = io.CopyN(tw, r, h.Size); err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	// Directory added by AddEmptyDir.
+	if len(f.absPath) == 0 {
+		h := *f.Header
+		setOwner(&h, ps.opts)
+		return tw.WriteHeader(&h)
 	}
 
-	return cae.Copy(f.Name, f.absPath)
+	fi, err := os.Lstat(f.absPath)
+	if err != nil {
+		return err
+	}
+	return packFile(f.absPath, strings.TrimSuffix(f.Name, "/"), tw, fi, ps)
 }
 
-// Flush saves changes to original zip file if any.
-func (tz *TzArchive) Flush() (err error) {
-	if !tz.isHasChanged || (tz.ReadCloser == nil && !tz.isHasWriter) {
-		return nil
+// writeEntries writes given entries to io.Writer as a new compressed tar
+// stream. Entries from original archive are copied from tr as they are,
+// which must be in the same order as in original archive.
+func (tz *TzArchive) writeEntries(w io.Writer, files []*File, tr *tar.Reader) error {
+	cw, err := newCompressor(tz.Compression, w)
+	if err != nil {
+		return err
 	}
+	tw := tar.NewWriter(cw)
+	ps := newPackState(tz.PackOptions)
 
-	// Extract to tmp path and pack back.
-	tmpPath := filepath.Join(os.TempDir(), "cae", filepath.Base(tz.FileName))
-	_ = os.RemoveAll(tmpPath)
-	_ = os.MkdirAll(tmpPath, os.ModePerm)
-	defer func() { _ = os.RemoveAll(tmpPath) }()
+	indexes := make(map[*tar.Header]int)
+	if tz.ReadCloser != nil {
+		for i, h := range tz.File {
+			indexes[h] = i
+		}
+	}
 
-	// Copy post-added files.
-	for _, f := range tz.files {
-		if strings.HasSuffix(f.Name, "/") {
-			_ = os.MkdirAll(filepath.Join(tmpPath, f.Name), os.ModePerm)
-			continue
-		} else if !cae.IsExist(f.absPath) {
+	next := 0 // Index of next entry in tr.
+	for _, f := range files {
+		if f.h == nil {
+			if err = writeFile(tw, f, ps); err != nil {
+				return err
+			}
 			continue
 		}
 
-		relPath := filepath.Join(tmpPath, f.Name)
-		_ = os.MkdirAll(filepath.Dir(relPath), os.ModePerm)
-		if err := cae.Copy(relPath, f.absPath); err != nil {
+		idx, ok := indexes[f.h]
+		if !ok || idx < next {
+			return fmt.Errorf("tz: entry %q is out of order", f.Name)
+		}
+		for ; next <= idx; next++ {
+			if _, err = tr.Next(); err != nil {
+				return err
+			}
+		}
+
+		h := *f.Header
+		if err = tw.WriteHeader(&h); err != nil {
+			return err
+		} else if _, err = io.Copy(tw, tr); err != nil {
 			return err
 		}
 	}
+	return closeTar(tw, cw, w, tz.Compression)
+}
 
-	if !tz.isHasWriter {
-		tz.ReadCloser, err = openReader(tz.FileName)
-		if err != nil {
-			return err
+// appendOffset returns the offset of trailer member in original archive if
+// changes can be appended to it, that is only new entries are added to a
+// Gzip archive which ends with the trailer member.
+func (tz *TzArchive) appendOffset() (int64, bool) {
+	if tz.Compression != Gzip || len(tz.files) < len(tz.File) {
+		return 0, false
+	}
+	for i, h := range tz.File {
+		if tz.files[i].h != h || len(tz.files[i].oldName) > 0 {
+			return 0, false
 		}
-		tz.syncFiles()
+	}
+	for _, f := range tz.files[len(tz.File):] {
+		if f.h != nil {
+			return 0, false
+		}
+	}
 
-		tr, f, err := openFile(tz.FileName)
-		if err != nil {
+	fi, err := tz.ReadCloser.f.Stat()
+	if err != nil {
+		return 0, false
+	}
+	offset := fi.Size() - int64(len(gzipTrailer))
+	if offset < 0 {
+		return 0, false
+	}
+	buf := make([]byte, len(gzipTrailer))
+	if _, err = tz.ReadCloser.f.ReadAt(buf, offset); err != nil || !bytes.Equal(buf, gzipTrailer) {
+		return 0, false
+	}
+	return offset, true
+}
+
+// writeTo writes changes of TzArchive to io.Writer. It copies compressed
+// members of original archive and appends new entries as a new member if
+// possible, otherwise rewrites the tar stream with untouched entries copied
+// from original archive.
+func (tz *TzArchive) writeTo(w io.Writer) error {
+	if offset, ok := tz.appendOffset(); ok {
+		if _, err := io.Copy(w, io.NewSectionReader(tz.ReadCloser.f, 0, offset)); err != nil {
 			return err
 		}
-		defer f.Close()
+		return tz.writeEntries(w, tz.files[len(tz.File):], nil)
+	}
 
-		i := 0
-		for {
-			h, err := tr.Next()
-			if err == io.EOF {
-				break
-			} else if err != nil {
-				return err
-			}
+	tr, rc, _, err := newReader(io.NewSectionReader(tz.ReadCloser.f, 0, math.MaxInt64))
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return tz.writeEntries(w, tz.files, tr)
+}
 
-			if h.Typeflag == tar.TypeDir {
-				os.MkdirAll(path.Join(tmpPath, h.Name), os.ModePerm)
-				continue
-			}
+// Flush saves changes to original tar file if any.
+// Changes are written to a temporary file next to the original one,
+// which then replaces the original file.
+func (tz *TzArchive) Flush() (err error) {
+	if !tz.isHasChanged || (tz.ReadCloser == nil && !tz.isHasWriter) {
+		return nil
+	}
 
-			// Relative path inside zip temporary changed.
-			fileName := tz.files[i].Name
-			tz.files[i].Name = path.Join(tmpPath, fileName)
-			if err := tz.extractFile(tz.files[i], tr); err != nil {
-				return err
-			}
-			// Change back here.
-			tz.files[i].Name = fileName
-			i++
+	if tz.isHasWriter {
+		if err = tz.writeEntries(tz.writer, tz.files, nil); err != nil {
+			return err
 		}
+		tz.isHasChanged = false
+		return nil
 	}
 
-	if tz.isHasWriter {
-		return packToWriter(tmpPath, tz.writer, defaultPackFunc, true)
+	fi, err := os.Stat(tz.FileName)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(tz.FileName), "."+filepath.Base(tz.FileName)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if err = tz.writeTo(tmp); err != nil {
+		tmp.Close()
+		return err
+	} else if err = tmp.Chmod(fi.Mode()); err != nil {
+		tmp.Close()
+		return err
+	} else if err = tmp.Close(); err != nil {
+		return err
 	}
 
-	if err := PackTo(tmpPath, tz.FileName); err != nil {
+	// Original file must be closed before replacing on some platforms.
+	if err = tz.ReadCloser.Close(); err != nil {
+		return err
+	}
+	tz.ReadCloser = nil
+	if err = os.Rename(tmpPath, tz.FileName); err != nil {
 		return err
 	}
 	return tz.Open(tz.FileName, os.O_RDWR|os.O_TRUNC, tz.Permission)
 }
 
+// packState contains options and states shared by a packing operation.
+type packState struct {
+	opts PackOptions
+	// links maps device and inode numbers of files which have multiple
+	// hard links to their first paths in archive.
+	links map[[2]uint64]string
+}
+
+func newPackState(opts PackOptions) *packState {
+	return &packState{
+		opts:  opts,
+		links: make(map[[2]uint64]string),
+	}
+}
+
 // packFile packs a file or directory to tar.Writer.
-func packFile(srcFile string, recPath string, tw *tar.Writer, fi os.FileInfo) (err error) {
+func packFile(srcFile string, recPath string, tw *tar.Writer, fi os.FileInfo, ps *packState) (err error) {
 	if fi.IsDir() {
 		h, err := tar.FileInfoHeader(fi, "")
 		if err != nil {
 			return err
 		}
 		h.Name = recPath + "/"
+		setOwner(h, ps.opts)
 
 		if err = tw.WriteHeader(h); err != nil {
 			return err
@@ -278,16 +460,31 @@ func packFile(srcFile string, recPath string, tw *tar.Writer, fi os.FileInfo) (e
 			return err
 		}
 		h.Name = recPath
+		setOwner(h, ps.opts)
+
+		// Pack the same file as hard link after it is seen for the first time.
+		if id, ok := fileID(fi); ok && h.Typeflag == tar.TypeReg {
+			if first, ok := ps.links[id]; ok {
+				h.Typeflag = tar.TypeLink
+				h.Linkname = first
+				h.Size = 0
+			} else {
+				ps.links[id] = recPath
+			}
+		}
 
 		if err = tw.WriteHeader(h); err != nil {
 			return err
 		}
 
-		if len(target) == 0 {
+		// Only regular files have content, opening FIFOs may block forever.
+		if h.Typeflag == tar.TypeReg {
 			f, err := os.Open(srcFile)
 			if err != nil {
 				return err
 			}
+			defer f.Close()
+
 			if _, err = io.Copy(tw, f); err != nil {
 				return err
 			}
@@ -298,7 +495,7 @@ func packFile(srcFile string, recPath string, tw *tar.Writer, fi os.FileInfo) (e
 
 // packDir packs a directory and its subdirectories and files
 // recursively to zip.Writer.
-func packDir(srcPath string, recPath string, tw *tar.Writer, fn cae.HookFunc) error {
+func packDir(srcPath string, recPath string, tw *tar.Writer, fn cae.HookFunc, ps *packState) error {
 	dir, err := os.Open(srcPath)
 	if err != nil {
 		return err
@@ -318,19 +515,26 @@ func packDir(srcPath string, recPath string, tw *tar.Writer, fn cae.HookFunc) er
 		// Append path
 		curPath := srcPath + "/" + fi.Name()
 		tmpRecPath := filepath.Join(recPath, fi.Name())
-		if err = fn(curPath, fi); err != nil {
+		name := filepath.ToSlash(tmpRecPath)
+		if fi.IsDir() && cae.SkipDir(ps.opts.PackMatcher, name) {
+			continue
+		} else if !fi.IsDir() && !cae.Matches(ps.opts.PackMatcher, name, false) {
+			continue
+		} else if err = fn(curPath, fi); err != nil {
 			continue
 		}
 
 		// Check it is directory or file
 		if fi.IsDir() {
-			if err = packFile(srcPath, tmpRecPath, tw, fi); err != nil {
-				return err
+			if cae.Matches(ps.opts.PackMatcher, name, true) {
+				if err = packFile(srcPath, tmpRecPath, tw, fi, ps); err != nil {
+					return err
+				}
 			}
 
-			err = packDir(curPath, tmpRecPath, tw, fn)
+			err = packDir(curPath, tmpRecPath, tw, fn, ps)
 		} else {
-			err = packFile(curPath, tmpRecPath, tw, fi)
+			err = packFile(curPath, tmpRecPath, tw, fi, ps)
 		}
 		if err != nil {
 			return err
@@ -340,58 +544,135 @@ func packDir(srcPath string, recPath string, tw *tar.Writer, fn cae.HookFunc) er
 }
 
 // packToWriter packs given path object to io.Writer.
-func packToWriter(srcPath string, w io.Writer, fn func(fullName string, fi os.FileInfo) error, includeDir bool) error {
-	gw := gzip.NewWriter(w)
-	defer gw.Close()
-
-	tw := tar.NewWriter(gw)
-	defer tw.Close()
-
-	f, err := os.Open(srcPath)
+func packToWriter(srcPath string, w io.Writer, fn func(fullName string, fi os.FileInfo) error, includeDir bool, compression string, opts PackOptions) (err error) {
+	fi, err := os.Stat(srcPath)
 	if err != nil {
 		return err
 	}
-	fi, err := f.Stat()
+
+	cw, err := newCompressor(compression, w)
 	if err != nil {
 		return err
 	}
+	tw := tar.NewWriter(cw)
+	defer func() {
+		if cerr := closeTar(tw, cw, w, compression); err == nil {
+			err = cerr
+		}
+	}()
 
 	basePath := filepath.Base(srcPath)
+	ps := newPackState(opts)
 
 	if fi.IsDir() {
 		if includeDir {
-			if err = packFile(srcPath, basePath, tw, fi); err != nil {
-				return err
+			if cae.Matches(opts.PackMatcher, basePath, true) {
+				if err = packFile(srcPath, basePath, tw, fi, ps); err != nil {
+					return err
+				}
 			}
 		} else {
 			basePath = ""
 		}
-		return packDir(srcPath, basePath, tw, fn)
+		return packDir(srcPath, basePath, tw, fn, ps)
 	}
 
-	return packFile(srcPath, basePath, tw, fi)
+	return packFile(srcPath, basePath, tw, fi, ps)
 }
 
 // packTo packs given source path object to target path.
-func packTo(srcPath, destPath string, fn cae.HookFunc, includeDir bool) error {
+func packTo(srcPath, destPath string, fn cae.HookFunc, includeDir bool, compression string, opts PackOptions) error {
 	fw, err := os.Create(destPath)
 	if err != nil {
 		return err
 	}
 	defer fw.Close()
 
-	return packToWriter(srcPath, fw, fn, includeDir)
+	return packToWriter(srcPath, fw, fn, includeDir, compression, opts)
+}
+
+// packFSFile packs a file or directory of fs.FS to tar.Writer.
+func packFSFile(fsys fs.FS, name, recPath string, tw *tar.Writer, fi fs.FileInfo, ps *packState) error {
+	h, err := tar.FileInfoHeader(fi, "")
+	if err != nil {
+		return err
+	}
+	h.Name = recPath
+	if fi.IsDir() {
+		h.Name += "/"
+	}
+	setOwner(h, ps.opts)
+	if err = tw.WriteHeader(h); err != nil {
+		return err
+	} else if fi.IsDir() {
+		return nil
+	}
+
+	f, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// PackFS packs the file tree rooted at root of fsys to io.Writer with given
+// options, e.g. an embed.FS. Entries are named by paths relative to root,
+// use "." to pack the whole file system.
+func PackFS(fsys fs.FS, root string, w io.Writer, opts PackOptions) (err error) {
+	compression := opts.Compression
+	if len(compression) == 0 {
+		compression = Gzip
+	}
+	cw, err := newCompressor(compression, w)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(cw)
+	defer func() {
+		if cerr := closeTar(tw, cw, w, compression); err == nil {
+			err = cerr
+		}
+	}()
+
+	ps := newPackState(opts)
+	return cae.WalkFS(fsys, root, func(name, relPath string, fi fs.FileInfo) error {
+		if fi.IsDir() && cae.SkipDir(ps.opts.PackMatcher, relPath) {
+			return fs.SkipDir
+		} else if !cae.Matches(ps.opts.PackMatcher, relPath, fi.IsDir()) {
+			return nil
+		}
+		return packFSFile(fsys, name, relPath, tw, fi, ps)
+	})
 }
 
 // PackToFunc packs the complete archive to the specified destination.
 // It accepts a function as a middleware for custom operations.
+// The compression is chosen by extension of destination, and defaults to Gzip.
 func PackToFunc(srcPath, destPath string, fn func(fullName string, fi os.FileInfo) error, includeDir ...bool) error {
 	isIncludeDir := false
 	if len(includeDir) > 0 && includeDir[0] {
 		isIncludeDir = true
 	}
 
-	return packTo(srcPath, destPath, fn, isIncludeDir)
+	return packTo(srcPath, destPath, fn, isIncludeDir, compressionByExt(destPath), PackOptions{})
+}
+
+// PackToWithOptions packs the complete archive to the specified destination
+// with given options.
+func PackToWithOptions(srcPath, destPath string, opts PackOptions, includeDir ...bool) error {
+	isIncludeDir := false
+	if len(includeDir) > 0 && includeDir[0] {
+		isIncludeDir = true
+	}
+
+	compression := opts.Compression
+	if len(compression) == 0 {
+		compression = compressionByExt(destPath)
+	}
+	return packTo(srcPath, destPath, defaultPackFunc, isIncludeDir, compression, opts)
 }
 
 var defaultPackFunc = func(fullName string, fi os.FileInfo) error {
@@ -409,6 +690,7 @@ var defaultPackFunc = func(fullName string, fi os.FileInfo) error {
 
 // PackTo packs the whole archive to the specified destination.
 // Call Flush() will automatically call this in the end.
+// The compression is chosen by extension of destination, and defaults to Gzip.
 func PackTo(srcPath, destPath string, includeDir ...bool) error {
 	return PackToFunc(srcPath, destPath, defaultPackFunc, includeDir...)
 }
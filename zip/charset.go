@@ -0,0 +1,214 @@
+// Copyright 2020 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package zip
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"hash/crc32"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+// A NameDecoder decodes raw name of an entry which is not flagged as UTF-8,
+// e.g. names stored in local code page by archivers on Windows.
+type NameDecoder func(name []byte) (string, error)
+
+func decode(enc encoding.Encoding, name []byte) (string, error) {
+	p, err := enc.NewDecoder().Bytes(name)
+	return string(p), err
+}
+
+// DecodeCP437 decodes name in IBM Code Page 437, the original charset of zip.
+func DecodeCP437(name []byte) (string, error) {
+	return decode(charmap.CodePage437, name)
+}
+
+// DecodeGBK decodes name in GBK, which is used by Simplified Chinese Windows.
+func DecodeGBK(name []byte) (string, error) {
+	return decode(simplifiedchinese.GB18030, name)
+}
+
+// DecodeShiftJIS decodes name in Shift-JIS, which is used by Japanese Windows.
+func DecodeShiftJIS(name []byte) (string, error) {
+	return decode(japanese.ShiftJIS, name)
+}
+
+// DecodeBig5 decodes name in Big5, which is used by Traditional Chinese Windows.
+func DecodeBig5(name []byte) (string, error) {
+	return decode(traditionalchinese.Big5, name)
+}
+
+// A NameEncoder encodes name of an entry in a legacy charset for archivers
+// which do not support UTF-8.
+type NameEncoder func(name string) ([]byte, error)
+
+func encode(enc encoding.Encoding, name string) ([]byte, error) {
+	return encoding.ReplaceUnsupported(enc.NewEncoder()).Bytes([]byte(name))
+}
+
+// EncodeCP437 encodes name in IBM Code Page 437, unsupported characters
+// are replaced.
+func EncodeCP437(name string) ([]byte, error) {
+	return encode(charmap.CodePage437, name)
+}
+
+// EncodeGBK encodes name in GBK, unsupported characters are replaced.
+func EncodeGBK(name string) ([]byte, error) {
+	return encode(simplifiedchinese.GBK, name)
+}
+
+// EncodeShiftJIS encodes name in Shift-JIS, unsupported characters are replaced.
+func EncodeShiftJIS(name string) ([]byte, error) {
+	return encode(japanese.ShiftJIS, name)
+}
+
+// EncodeBig5 encodes name in Big5, unsupported characters are replaced.
+func EncodeBig5(name string) ([]byte, error) {
+	return encode(traditionalchinese.Big5, name)
+}
+
+// nameScore returns how likely the name is decoded correctly, or -1 if
+// the name contains invalid or control characters. Kana are weighted more
+// since they rarely appear by mistake, and halfwidth forms less since they
+// appear when double-byte charsets are decoded as Shift-JIS.
+func nameScore(name string) float64 {
+	total, score := 0, 0.0
+	for _, r := range name {
+		total++
+		switch {
+		case r == utf8.RuneError || unicode.IsControl(r):
+			return -1
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana) && r < 0xff00:
+			score += 1.5
+		case r < utf8.RuneSelf,
+			unicode.Is(unicode.Han, r),
+			r >= 0x3000 && r <= 0x303f: // CJK symbols and punctuation.
+			score++
+		case r >= 0xff00 && r <= 0xffef: // Halfwidth and fullwidth forms.
+			score += 0.5
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return score / float64(total)
+}
+
+// DetectName is a NameDecoder which guesses charset of name. Names which
+// are valid UTF-8 are kept as they are, otherwise it picks the most likely
+// decoding of GBK, Shift-JIS and Big5, and falls back to CP437.
+// Like all heuristics, it may guess wrong for short names.
+func DetectName(name []byte) (string, error) {
+	if utf8.Valid(name) {
+		return string(name), nil
+	}
+
+	best, bestScore := "", 0.0
+	for _, dec := range []NameDecoder{DecodeGBK, DecodeShiftJIS, DecodeBig5} {
+		s, err := dec(name)
+		if err != nil {
+			continue
+		}
+		if score := nameScore(s); score > bestScore {
+			best, bestScore = s, score
+		}
+	}
+	if bestScore > 0 {
+		return best, nil
+	}
+	return DecodeCP437(name)
+}
+
+// unicodePath returns the UTF-8 name stored in Info-ZIP Unicode Path extra
+// field, which is only valid if CRC32 of raw name matches.
+func unicodePath(rawName string, extra []byte) (string, bool) {
+	data, ok := findExtra(extra, unicodePathExtraID)
+	if !ok || len(data) < 5 || data[0] != 1 ||
+		binary.LittleEndian.Uint32(data[1:]) != crc32.ChecksumIEEE([]byte(rawName)) ||
+		!utf8.Valid(data[5:]) {
+		return "", false
+	}
+	return string(data[5:]), true
+}
+
+// unicodePathExtra returns Info-ZIP Unicode Path extra field of name
+// which is stored as raw name in header.
+func unicodePathExtra(raw []byte, name string) []byte {
+	data := make([]byte, 5, 5+len(name))
+	data[0] = 1
+	binary.LittleEndian.PutUint32(data[1:], crc32.ChecksumIEEE(raw))
+	return appendExtra(nil, unicodePathExtraID, append(data, name...))
+}
+
+// isASCII returns true if s only contains ASCII characters.
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeName sets UTF-8 flag for non-ASCII name of header, or stores the name
+// encoded by given encoder along with Unicode Path extra field.
+func encodeName(fh *zip.FileHeader, enc NameEncoder) error {
+	if isASCII(fh.Name) {
+		return nil
+	} else if enc == nil {
+		fh.Flags |= 0x800
+		fh.NonUTF8 = false
+		return nil
+	}
+
+	raw, err := enc(fh.Name)
+	if err != nil {
+		return err
+	}
+	fh.Extra = append(fh.Extra, unicodePathExtra(raw, fh.Name)...)
+	fh.Name = string(raw)
+	fh.Flags &^= 0x800
+	fh.NonUTF8 = true
+	return nil
+}
+
+// decodeName decodes name of zip.File in place if it is not flagged as UTF-8,
+// by Unicode Path extra field or given decoder.
+func decodeName(f *zip.File, dec NameDecoder) error {
+	name, ok := unicodePath(f.Name, f.Extra)
+	if !ok {
+		if dec == nil || (!f.NonUTF8 && f.Flags&0x800 != 0) {
+			return nil
+		}
+
+		var err error
+		if name, err = dec([]byte(f.Name)); err != nil {
+			return err
+		}
+	}
+
+	f.Name = strings.ToValidUTF8(name, "\uFFFD")
+	f.Flags |= 0x800
+	f.NonUTF8 = false
+	return nil
+}
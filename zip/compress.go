@@ -0,0 +1,109 @@
+// Copyright 2020 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package zip
+
+import (
+	"archive/zip"
+	"compress/bzip2"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// Compression methods beyond zip.Store and zip.Deflate, compressors and
+// decompressors of Zstd and Xz need to be registered before use.
+const (
+	Bzip2 uint16 = 12
+	Zstd  uint16 = 93
+	Xz    uint16 = 95
+)
+
+// methodNames contains names of known compression methods.
+var methodNames = map[uint16]string{
+	zip.Store:   "store",
+	zip.Deflate: "deflate",
+	Bzip2:       "bzip2",
+	Zstd:        "zstd",
+	Xz:          "xz",
+}
+
+// methodName returns the name of compression method, the actual method
+// of WinZip AES encrypted entries is read from extra field.
+func methodName(method uint16, extra []byte) string {
+	if method == methodAES {
+		if ae, ok := parseAESExtra(extra); ok {
+			method = ae.method
+		}
+	}
+	if name, ok := methodNames[method]; ok {
+		return name
+	}
+	return fmt.Sprintf("method-%d", method)
+}
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = make(map[uint16]zip.Compressor)
+	decompressors = map[uint16]zip.Decompressor{
+		Bzip2: func(r io.Reader) io.ReadCloser {
+			return ioutil.NopCloser(bzip2.NewReader(r))
+		},
+	}
+)
+
+// RegisterCompressor registers or overrides the compressor of given method,
+// e.g. Zstd. Unlike zip.RegisterCompressor, it only applies to archives
+// written by this package, and nil removes the registration.
+func RegisterCompressor(method uint16, comp zip.Compressor) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+	if comp == nil {
+		delete(compressors, method)
+		return
+	}
+	compressors[method] = comp
+}
+
+// RegisterDecompressor registers or overrides the decompressor of given
+// method, e.g. Zstd. Unlike zip.RegisterDecompressor, it only applies to
+// archives opened by this package afterwards, and nil removes the registration.
+func RegisterDecompressor(method uint16, dcomp zip.Decompressor) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+	if dcomp == nil {
+		delete(decompressors, method)
+		return
+	}
+	decompressors[method] = dcomp
+}
+
+// registerCompressors registers all compressors to zip.Writer.
+func registerCompressors(zw *zip.Writer) {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+	for method, comp := range compressors {
+		zw.RegisterCompressor(method, comp)
+	}
+}
+
+// registerDecompressors registers all decompressors to zip.Reader.
+func registerDecompressors(zr *zip.Reader) {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+	for method, dcomp := range decompressors {
+		zr.RegisterDecompressor(method, dcomp)
+	}
+}
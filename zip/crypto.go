@@ -0,0 +1,478 @@
+// Copyright 2020 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package zip
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+)
+
+// A PasswordError is returned when the password is missing or wrong
+// for an encrypted entry.
+type PasswordError struct {
+	Name string // Name of the encrypted entry.
+}
+
+func (e *PasswordError) Error() string {
+	return fmt.Sprintf("zip: missing or wrong password for entry %q", e.Name)
+}
+
+const (
+	flagEncrypted      = 0x1
+	flagDataDescriptor = 0x8
+
+	methodAES    = 99
+	aesVersion1  = 1 // AE-1, CRC is stored.
+	aesVersion2  = 2 // AE-2, CRC is not stored.
+	aesStrength  = 3 // AES-256 for writing.
+	aesMACLen    = 10
+	aesPVLen     = 2
+	aesIterCount = 1000
+
+	zipCryptoHeaderLen = 12
+)
+
+// pbkdf2 derives a key of keyLen bytes from password and salt by PBKDF2
+// with HMAC-SHA1.
+func pbkdf2(password, salt []byte, iter, keyLen int) []byte {
+	prf := hmac.New(sha1.New, password)
+	key := make([]byte, 0, keyLen+prf.Size())
+	u := make([]byte, prf.Size())
+	t := make([]byte, prf.Size())
+	for block := uint32(1); len(key) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write([]byte{byte(block >> 24), byte(block >> 16), byte(block >> 8), byte(block)})
+		u = prf.Sum(u[:0])
+		copy(t, u)
+		for i := 1; i < iter; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
+
+// winZipCTR is the AES counter mode used by WinZip, the counter is
+// little-endian and starts from 1.
+type winZipCTR struct {
+	block   cipher.Block
+	counter [aes.BlockSize]byte
+	stream  [aes.BlockSize]byte
+	pos     int
+}
+
+func newWinZipCTR(block cipher.Block) *winZipCTR {
+	return &winZipCTR{
+		block: block,
+		pos:   aes.BlockSize,
+	}
+}
+
+func (s *winZipCTR) XORKeyStream(dst, src []byte) {
+	for i := range src {
+		if s.pos == aes.BlockSize {
+			for j := range s.counter {
+				s.counter[j]++
+				if s.counter[j] != 0 {
+					break
+				}
+			}
+			s.block.Encrypt(s.stream[:], s.counter[:])
+			s.pos = 0
+		}
+		dst[i] = src[i] ^ s.stream[s.pos]
+		s.pos++
+	}
+}
+
+// aesKeys derives encryption key, authentication key and password
+// verification value from password and salt.
+func aesKeys(password string, salt []byte) (key, macKey, pv []byte) {
+	keyLen := len(salt) * 2
+	dk := pbkdf2([]byte(password), salt, aesIterCount, 2*keyLen+aesPVLen)
+	return dk[:keyLen], dk[keyLen : 2*keyLen], dk[2*keyLen:]
+}
+
+// aesExtra is the WinZip AES extra field.
+type aesExtra struct {
+	version  uint16
+	strength byte
+	method   uint16 // Actual compression method.
+}
+
+// parseAESExtra returns the WinZip AES extra field in given extra fields.
+func parseAESExtra(extra []byte) (aesExtra, bool) {
+	data, ok := findExtra(extra, aesExtraID)
+	if !ok || len(data) < 7 || string(data[2:4]) != "AE" {
+		return aesExtra{}, false
+	}
+	return aesExtra{
+		version:  binary.LittleEndian.Uint16(data),
+		strength: data[4],
+		method:   binary.LittleEndian.Uint16(data[5:]),
+	}, true
+}
+
+// bytes returns the encoded extra field.
+func (e aesExtra) bytes() []byte {
+	data := make([]byte, 7)
+	binary.LittleEndian.PutUint16(data, e.version)
+	copy(data[2:], "AE")
+	data[4] = e.strength
+	binary.LittleEndian.PutUint16(data[5:], e.method)
+	return appendExtra(nil, aesExtraID, data)
+}
+
+// zipCrypto is the traditional PKWARE encryption.
+type zipCrypto struct {
+	keys [3]uint32
+}
+
+func newZipCrypto(password []byte) *zipCrypto {
+	z := &zipCrypto{keys: [3]uint32{0x12345678, 0x23456789, 0x34567890}}
+	for _, b := range password {
+		z.update(b)
+	}
+	return z
+}
+
+func crc32Update(crc uint32, b byte) uint32 {
+	return crc32.IEEETable[byte(crc)^b] ^ (crc >> 8)
+}
+
+func (z *zipCrypto) update(b byte) {
+	z.keys[0] = crc32Update(z.keys[0], b)
+	z.keys[1] = (z.keys[1]+z.keys[0]&0xff)*134775813 + 1
+	z.keys[2] = crc32Update(z.keys[2], byte(z.keys[1]>>24))
+}
+
+func (z *zipCrypto) XORKeyStream(dst, src []byte) {
+	for i := range src {
+		t := uint16(z.keys[2] | 2)
+		dst[i] = src[i] ^ byte(t*(t^1)>>8)
+		z.update(dst[i])
+	}
+}
+
+// decryptReader decrypts data read from r.
+type decryptReader struct {
+	r      io.Reader
+	stream cipher.Stream
+	mac    hash.Hash // Authentication of encrypted data, nil if not applicable.
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if r.mac != nil {
+		r.mac.Write(p[:n])
+	}
+	r.stream.XORKeyStream(p[:n], p[:n])
+	return n, err
+}
+
+// checksumReader verifies authentication code and CRC32 of content at the end.
+type checksumReader struct {
+	rc    io.ReadCloser
+	crc   hash.Hash32
+	size  uint64
+	f     *zip.File
+	check bool         // Whether to verify CRC32.
+	auth  func() error // Verifies authentication code, nil if not applicable.
+	err   error
+}
+
+func (r *checksumReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.rc.Read(p)
+	r.crc.Write(p[:n])
+	r.size += uint64(n)
+	if err == io.EOF {
+		if r.size != r.f.UncompressedSize64 {
+			err = io.ErrUnexpectedEOF
+		} else if r.auth != nil {
+			if aerr := r.auth(); aerr != nil {
+				err = aerr
+			}
+		}
+		if err == io.EOF && r.check && r.crc.Sum32() != r.f.CRC32 {
+			err = zip.ErrChecksum
+		}
+	}
+	r.err = err
+	return n, err
+}
+
+func (r *checksumReader) Close() error {
+	return r.rc.Close()
+}
+
+// decompressor returns the decompressor of given method.
+func decompressor(method uint16) zip.Decompressor {
+	switch method {
+	case zip.Store:
+		return ioutil.NopCloser
+	case zip.Deflate:
+		return flate.NewReader
+	}
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+	return decompressors[method]
+}
+
+// openEncrypted returns a reader of decrypted and decompressed content
+// of an encrypted entry, raw is the content read by zip.File.OpenRaw.
+func openEncrypted(f *zip.File, raw io.Reader, password string) (io.ReadCloser, error) {
+	size := int64(f.CompressedSize64)
+
+	method := f.Method
+	check := true
+	var dr *decryptReader
+	var auth func() error
+	if f.Method == methodAES {
+		extra, ok := parseAESExtra(f.Extra)
+		if !ok || extra.strength < 1 || extra.strength > 3 {
+			return nil, errors.New("zip: invalid AES extra field")
+		}
+		method = extra.method
+		check = extra.version == aesVersion1 && f.CRC32 != 0
+
+		salt := make([]byte, 4+4*int(extra.strength))
+		header := make([]byte, len(salt)+aesPVLen)
+		if _, err := io.ReadFull(raw, header); err != nil {
+			return nil, err
+		}
+		copy(salt, header)
+		key, macKey, pv := aesKeys(password, salt)
+		if !bytes.Equal(pv, header[len(salt):]) {
+			return nil, &PasswordError{Name: f.Name}
+		}
+
+		size -= int64(len(header) + aesMACLen)
+		if size < 0 {
+			return nil, zip.ErrFormat
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		dr = &decryptReader{
+			r:      io.LimitReader(raw, size),
+			stream: newWinZipCTR(block),
+			mac:    hmac.New(sha1.New, macKey),
+		}
+		auth = func() error {
+			code := make([]byte, aesMACLen)
+			if _, err := io.ReadFull(raw, code); err != nil {
+				return err
+			} else if !hmac.Equal(code, dr.mac.Sum(nil)[:aesMACLen]) {
+				return zip.ErrChecksum
+			}
+			return nil
+		}
+	} else {
+		zc := newZipCrypto([]byte(password))
+		header := make([]byte, zipCryptoHeaderLen)
+		if _, err := io.ReadFull(raw, header); err != nil {
+			return nil, err
+		}
+		zc.XORKeyStream(header, header)
+		// The last byte of header is high order byte of CRC32, or modification
+		// time if data descriptor is present.
+		verifier := byte(f.CRC32 >> 24)
+		if f.Flags&flagDataDescriptor != 0 {
+			verifier = byte(f.ModifiedTime >> 8)
+		}
+		if header[zipCryptoHeaderLen-1] != verifier {
+			return nil, &PasswordError{Name: f.Name}
+		}
+
+		size -= zipCryptoHeaderLen
+		if size < 0 {
+			return nil, zip.ErrFormat
+		}
+		dr = &decryptReader{
+			r:      io.LimitReader(raw, size),
+			stream: zc,
+		}
+	}
+
+	dcomp := decompressor(method)
+	if dcomp == nil {
+		return nil, zip.ErrAlgorithm
+	}
+	return &checksumReader{
+		rc:    dcomp(dr),
+		crc:   crc32.NewIEEE(),
+		f:     f,
+		check: check,
+		auth:  auth,
+	}, nil
+}
+
+// compressor returns the compressor of given method with deflate level.
+func compressor(method uint16, level int) zip.Compressor {
+	switch method {
+	case zip.Store:
+		return func(w io.Writer) (io.WriteCloser, error) {
+			return nopWriteCloser{w}, nil
+		}
+	case zip.Deflate:
+		return newDeflater(func() int { return level })
+	}
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+	return compressors[method]
+}
+
+// nopWriteCloser is an io.WriteCloser with a no-op Close method.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// encryptWriter encrypts data and writes to w.
+type encryptWriter struct {
+	w      io.Writer
+	stream cipher.Stream
+	mac    hash.Hash
+	n      int64
+	buf    []byte
+}
+
+func (w *encryptWriter) Write(p []byte) (int, error) {
+	if cap(w.buf) < len(p) {
+		w.buf = make([]byte, len(p))
+	}
+	buf := w.buf[:len(p)]
+	w.stream.XORKeyStream(buf, p)
+	w.mac.Write(buf)
+	n, err := w.w.Write(buf)
+	w.n += int64(n)
+	return n, err
+}
+
+// aesWriter compresses and encrypts content of an entry with AES-256.
+type aesWriter struct {
+	fh *zip.FileHeader
+	cw io.WriteCloser
+	ew *encryptWriter
+	n  int64 // Number of uncompressed bytes.
+}
+
+func (w *aesWriter) Write(p []byte) (int, error) {
+	n, err := w.cw.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// Close writes authentication code and updates sizes of the entry, which
+// are written in data descriptor and central directory by zip.Writer.
+func (w *aesWriter) Close() error {
+	if err := w.cw.Close(); err != nil {
+		return err
+	}
+	if _, err := w.ew.w.Write(w.ew.mac.Sum(nil)[:aesMACLen]); err != nil {
+		return err
+	}
+
+	saltLen := 4 + 4*aesStrength
+	w.fh.CompressedSize64 = uint64(saltLen + aesPVLen + int(w.ew.n) + aesMACLen)
+	w.fh.UncompressedSize64 = uint64(w.n)
+	if w.fh.CompressedSize64 > uint32max || w.fh.UncompressedSize64 > uint32max {
+		w.fh.CompressedSize = uint32max
+		w.fh.UncompressedSize = uint32max
+	} else {
+		w.fh.CompressedSize = uint32(w.fh.CompressedSize64)
+		w.fh.UncompressedSize = uint32(w.fh.UncompressedSize64)
+	}
+	return nil
+}
+
+const uint32max = (1 << 32) - 1
+
+// createEncrypted adds an entry encrypted with AES-256 to zip.Writer, the
+// returned writer must be closed after writing content.
+func createEncrypted(zw *zip.Writer, fh *zip.FileHeader, password string, level int) (io.WriteCloser, error) {
+	comp := compressor(fh.Method, level)
+	if comp == nil {
+		return nil, zip.ErrAlgorithm
+	}
+
+	salt := make([]byte, 4+4*aesStrength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key, macKey, pv := aesKeys(password, salt)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	fh.Extra = append(fh.Extra, aesExtra{
+		version:  aesVersion2,
+		strength: aesStrength,
+		method:   fh.Method,
+	}.bytes()...)
+	fh.Method = methodAES
+	fh.Flags |= flagEncrypted | flagDataDescriptor
+	fh.CRC32 = 0
+	fh.CompressedSize64 = 0
+	fh.UncompressedSize64 = 0
+	fh.ReaderVersion = 51
+	fh.CreatorVersion = fh.CreatorVersion&0xff00 | 51
+
+	raw, err := zw.CreateRaw(fh)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = raw.Write(salt); err != nil {
+		return nil, err
+	} else if _, err = raw.Write(pv); err != nil {
+		return nil, err
+	}
+
+	ew := &encryptWriter{
+		w:      raw,
+		stream: newWinZipCTR(block),
+		mac:    hmac.New(sha1.New, macKey),
+	}
+	cw, err := comp(ew)
+	if err != nil {
+		return nil, err
+	}
+	return &aesWriter{fh: fh, cw: cw, ew: ew}, nil
+}
@@ -0,0 +1,158 @@
+// Copyright 2020 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package zip
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"os"
+	"time"
+)
+
+// IDs of extra fields.
+const (
+	extTimeExtraID     = 0x5455 // Extended timestamp.
+	unixExtraID        = 0x7875 // Info-ZIP Unix, with user and group IDs.
+	unicodePathExtraID = 0x7075 // Info-ZIP Unicode Path.
+	aesExtraID         = 0x9901 // WinZip AES.
+)
+
+// findExtra returns data of the extra field with given ID.
+func findExtra(extra []byte, id uint16) ([]byte, bool) {
+	for len(extra) >= 4 {
+		fieldID := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		extra = extra[4:]
+		if size > len(extra) {
+			break
+		} else if fieldID == id {
+			return extra[:size], true
+		}
+		extra = extra[size:]
+	}
+	return nil, false
+}
+
+// appendExtra appends an extra field with given ID and data.
+func appendExtra(extra []byte, id uint16, data []byte) []byte {
+	b := make([]byte, 4, 4+len(data))
+	binary.LittleEndian.PutUint16(b, id)
+	binary.LittleEndian.PutUint16(b[2:], uint16(len(data)))
+	return append(extra, append(b, data...)...)
+}
+
+// removeExtra returns a copy of extra without fields of given ID.
+func removeExtra(extra []byte, id uint16) []byte {
+	var out []byte
+	for len(extra) >= 4 {
+		fieldID := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if 4+size > len(extra) {
+			break
+		} else if fieldID != id {
+			out = append(out, extra[:4+size]...)
+		}
+		extra = extra[4+size:]
+	}
+	return append(out, extra...)
+}
+
+// msDosTime returns MS-DOS date and time of t.
+func msDosTime(t time.Time) (date, tm uint16) {
+	date = uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	tm = uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	return date, tm
+}
+
+// setTimes stores modification time, and access time if not zero, in
+// extended timestamp extra field of header.
+func setTimes(fh *zip.FileHeader, mtime, atime time.Time) {
+	ts := []byte{1, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(ts[1:], uint32(mtime.Unix()))
+	if !atime.IsZero() {
+		ts[0] |= 2
+		ts = append(ts, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(ts[5:], uint32(atime.Unix()))
+	}
+	fh.Extra = appendExtra(fh.Extra, extTimeExtraID, ts)
+
+	// Modified is cleared to prevent zip.Writer from adding another
+	// extended timestamp.
+	fh.ModifiedDate, fh.ModifiedTime = msDosTime(mtime)
+	fh.Modified = time.Time{}
+}
+
+// setExtra stores modification and access time in extended timestamp extra
+// field, and owner in Info-ZIP Unix extra field of header.
+func setExtra(fh *zip.FileHeader, fi os.FileInfo) {
+	atime, _ := fileAtime(fi)
+	setTimes(fh, fi.ModTime(), atime)
+
+	if uid, gid, ok := fileOwner(fi); ok {
+		ids := []byte{1, 4, 0, 0, 0, 0, 4, 0, 0, 0, 0}
+		binary.LittleEndian.PutUint32(ids[2:], uint32(uid))
+		binary.LittleEndian.PutUint32(ids[7:], uint32(gid))
+		fh.Extra = appendExtra(fh.Extra, unixExtraID, ids)
+	}
+}
+
+// fileTimes returns modification and access time of zip.File, which are
+// read from extended timestamp extra field if present.
+func fileTimes(f *zip.File) (mtime, atime time.Time) {
+	mtime = f.Modified
+	if mtime.IsZero() {
+		mtime = f.ModTime()
+	}
+	atime = mtime
+
+	ts, ok := findExtra(f.Extra, extTimeExtraID)
+	if !ok || len(ts) < 1 {
+		return mtime, atime
+	}
+	flags, ts := ts[0], ts[1:]
+	if flags&1 != 0 && len(ts) >= 4 {
+		mtime = time.Unix(int64(int32(binary.LittleEndian.Uint32(ts))), 0)
+		atime = mtime
+		ts = ts[4:]
+	}
+	if flags&2 != 0 && len(ts) >= 4 {
+		atime = time.Unix(int64(int32(binary.LittleEndian.Uint32(ts))), 0)
+	}
+	return mtime, atime
+}
+
+// fileOwnerExtra returns the owner of zip.File stored in Info-ZIP Unix
+// extra field.
+func fileOwnerExtra(f *zip.File) (uid, gid int, ok bool) {
+	ids, ok := findExtra(f.Extra, unixExtraID)
+	if !ok || len(ids) < 2 || ids[0] != 1 {
+		return 0, 0, false
+	}
+
+	readID := func(b []byte) (int, []byte, bool) {
+		if len(b) < 1 || len(b) < 1+int(b[0]) || b[0] > 8 {
+			return 0, nil, false
+		}
+		var buf [8]byte
+		copy(buf[:], b[1:1+b[0]])
+		return int(binary.LittleEndian.Uint64(buf[:])), b[1+b[0]:], true
+	}
+	uid, rest, ok := readID(ids[1:])
+	if !ok {
+		return 0, 0, false
+	}
+	gid, _, ok = readID(rest)
+	return uid, gid, ok
+}
@@ -0,0 +1,112 @@
+// Copyright 2020 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package zip
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/unknwon/cae"
+)
+
+// A MethodFunc returns the compression method of an entry by its name and
+// uncompressed size, e.g. zip.Store, zip.Deflate or a registered one like Zstd.
+type MethodFunc func(name string, size int64) uint16
+
+// storedExts contains extensions of files which are usually compressed already.
+var storedExts = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true,
+	".zip": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true,
+	".zst": true, ".lz4": true, ".7z": true, ".rar": true, ".jar": true,
+	".mp3": true, ".mp4": true, ".m4a": true, ".mkv": true, ".mov": true,
+	".avi": true, ".webm": true, ".ogg": true, ".flac": true,
+}
+
+// DefaultMethod returns zip.Store for files which are usually compressed
+// already, e.g. ".jpg", ".zip" and ".mp4", and zip.Deflate otherwise.
+func DefaultMethod(name string, size int64) uint16 {
+	if storedExts[strings.ToLower(path.Ext(name))] {
+		return zip.Store
+	}
+	return zip.Deflate
+}
+
+// PackOptions contains options for packing archives.
+type PackOptions struct {
+	// Method picks compression method of each entry, DefaultMethod is used if nil.
+	Method MethodFunc
+	// Level is the deflate level from flate.HuffmanOnly to flate.BestCompression,
+	// zero value means flate.DefaultCompression. Use Method to store entries
+	// without compression.
+	Level int
+	// NameEncoder stores non-ASCII names in a legacy charset for old archivers,
+	// e.g. EncodeCP437, along with Unicode Path extra field for UTF-8 name.
+	// Non-ASCII names are stored in UTF-8 with UTF-8 flag set if nil.
+	NameEncoder NameEncoder
+	// Password encrypts new entries with AES-256 if not empty. For ZipArchive,
+	// it is used for decrypting entries as well.
+	Password string
+	// PackMatcher selects files to be packed by their names in archive
+	// if not nil, directories matched by cae.DirSkipper are not walked into.
+	PackMatcher cae.Matcher
+}
+
+// method returns the compression method of given entry.
+func (opts PackOptions) method(name string, size int64) uint16 {
+	if opts.Method != nil {
+		return opts.Method(name, size)
+	}
+	return DefaultMethod(name, size)
+}
+
+// newDeflater returns a zip.Compressor with deflate level given by level func.
+func newDeflater(level func() int) zip.Compressor {
+	return func(w io.Writer) (io.WriteCloser, error) {
+		lvl := level()
+		if lvl == 0 {
+			lvl = flate.DefaultCompression
+		}
+		return flate.NewWriter(w, lvl)
+	}
+}
+
+// newWriter returns a new zip.Writer writing to w with registered
+// compressors and the deflate level.
+func (opts PackOptions) newWriter(w io.Writer) *zip.Writer {
+	zw := zip.NewWriter(w)
+	registerCompressors(zw)
+	if opts.Level != 0 {
+		zw.RegisterCompressor(zip.Deflate, newDeflater(func() int { return opts.Level }))
+	}
+	return zw
+}
+
+// create adds an entry to zip.Writer and returns the writer of its content,
+// which must be closed after writing. Files are encrypted if Password is set.
+func (opts PackOptions) create(zw *zip.Writer, fh *zip.FileHeader) (io.WriteCloser, error) {
+	isDir := strings.HasSuffix(fh.Name, "/")
+	if err := encodeName(fh, opts.NameEncoder); err != nil {
+		return nil, err
+	}
+
+	if len(opts.Password) == 0 || isDir {
+		w, err := zw.CreateHeader(fh)
+		return nopWriteCloser{w}, err
+	}
+	return createEncrypted(zw, fh, opts.Password, opts.Level)
+}
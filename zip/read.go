@@ -16,6 +16,9 @@ package zip
 
 import (
 	"archive/zip"
+	"errors"
+	"io"
+	"io/ioutil"
 	"os"
 	"strings"
 
@@ -44,6 +47,13 @@ func (z *ZipArchive) Open(name string, flag int, perm os.FileMode) error {
 	if err != nil {
 		return err
 	}
+	registerDecompressors(&rc.Reader)
+	for _, f := range rc.File {
+		if err = decodeName(f, z.NameDecoder); err != nil {
+			rc.Close()
+			return err
+		}
+	}
 
 	z.ReadCloser = rc
 	z.FileName = name
@@ -52,18 +62,88 @@ func (z *ZipArchive) Open(name string, flag int, perm os.FileMode) error {
 	z.Flag = flag
 	z.Permission = perm
 	z.isHasChanged = false
+	z.view = nil
 
 	z.files = make([]*File, z.NumFiles)
 	for i, f := range rc.File {
-		z.files[i] = &File{}
-		z.files[i].FileHeader, err = zip.FileInfoHeader(f.FileInfo())
-		if err != nil {
-			return err
+		// Keep a copy of original header, only the name is normalized.
+		fh := f.FileHeader
+		fh.Name = cae.Clean(strings.ReplaceAll(f.Name, "\\", "/"))
+		if f.FileInfo().IsDir() && !strings.HasSuffix(fh.Name, "/") {
+			fh.Name += "/"
 		}
-		z.files[i].Name = cae.Clean(strings.ReplaceAll(f.Name, "\\", "/"))
-		if f.FileInfo().IsDir() && !strings.HasSuffix(z.files[i].Name, "/") {
-			z.files[i].Name += "/"
+		z.files[i] = &File{
+			FileHeader: &fh,
+			zf:         f,
 		}
 	}
 	return nil
 }
+
+// View returns a read-only file system of entries in the opened archive,
+// changes are not visible until flushed. Directories which have no entry
+// are synthesized, and encrypted entries are decrypted with Password.
+func (z *ZipArchive) View() cae.FS {
+	if z.view != nil {
+		return z.view
+	}
+
+	var entries []cae.FSEntry
+	if z.ReadCloser != nil {
+		entries = make([]cae.FSEntry, 0, len(z.File))
+		for _, f := range z.File {
+			f := f
+			entries = append(entries, cae.FSEntry{
+				Name: strings.ReplaceAll(f.Name, "\\", "/"),
+				Info: f.FileInfo(),
+				Open: func() (io.ReadCloser, error) {
+					return z.open(f)
+				},
+			})
+		}
+	}
+	z.view = cae.NewFS(entries)
+	return z.view
+}
+
+// openFile opens the content of an entry, which may be added later and
+// not flushed yet.
+func (z *ZipArchive) openFile(f *File) (io.ReadCloser, error) {
+	switch {
+	case f.zf != nil:
+		return z.open(f.zf)
+	case f.isStream:
+		return nil, &os.PathError{Op: "open", Path: f.Name, Err: errors.New("content of io.Reader is not available before flushing")}
+	case f.open != nil:
+		return f.open()
+	case len(f.absPath) > 0:
+		return os.Open(f.absPath)
+	}
+	return ioutil.NopCloser(strings.NewReader("")), nil
+}
+
+// OpenEntry opens the content of entry with given name without extracting
+// it, entries added but not flushed yet can be opened as well unless they
+// are added by AddReader from a one-shot io.Reader.
+func (z *ZipArchive) OpenEntry(name string) (io.ReadCloser, error) {
+	for _, f := range z.files {
+		if f.Name != name {
+			continue
+		} else if strings.HasSuffix(f.Name, "/") {
+			return nil, &os.PathError{Op: "open", Path: name, Err: errors.New("is a directory")}
+		}
+		return z.openFile(f)
+	}
+	return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+}
+
+// ReadFile reads the content of entry with given name without extracting
+// it, entries added but not flushed yet can be read as well.
+func (z *ZipArchive) ReadFile(name string) ([]byte, error) {
+	rc, err := z.OpenEntry(name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
@@ -24,12 +24,18 @@ import (
 // A StreamArchive represents a streamable archive.
 type StreamArchive struct {
 	*zip.Writer
+
+	// Options for packing entries.
+	PackOptions
 }
 
 // NewStreamArachive returns a new streamable archive with given io.Writer.
 // It's caller's responsibility to close io.Writer and streamer after operation.
 func NewStreamArachive(w io.Writer) *StreamArchive {
-	return &StreamArchive{zip.NewWriter(w)}
+	s := &StreamArchive{Writer: zip.NewWriter(w)}
+	registerCompressors(s.Writer)
+	s.Writer.RegisterCompressor(zip.Deflate, newDeflater(func() int { return s.Level }))
+	return s
 }
 
 // StreamFile streams a file or directory entry into StreamArchive.
@@ -39,8 +45,12 @@ func (s *StreamArchive) StreamFile(relPath string, fi os.FileInfo, data []byte)
 		if err != nil {
 			return err
 		}
+		setExtra(fh, fi)
 		fh.Name = relPath + "/"
-		if _, err = s.Writer.CreateHeader(fh); err != nil {
+		fw, err := s.create(s.Writer, fh)
+		if err != nil {
+			return err
+		} else if err = fw.Close(); err != nil {
 			return err
 		}
 	} else {
@@ -48,13 +58,16 @@ func (s *StreamArchive) StreamFile(relPath string, fi os.FileInfo, data []byte)
 		if err != nil {
 			return err
 		}
+		setExtra(fh, fi)
 		fh.Name = filepath.Join(relPath, fi.Name())
-		fh.Method = zip.Deflate
-		fw, err := s.Writer.CreateHeader(fh)
+		fh.Method = s.method(fh.Name, fi.Size())
+		fw, err := s.create(s.Writer, fh)
 		if err != nil {
 			return err
 		} else if _, err = fw.Write(data); err != nil {
 			return err
+		} else if err = fw.Close(); err != nil {
+			return err
 		}
 	}
 	return nil
@@ -66,12 +79,15 @@ func (s *StreamArchive) StreamReader(relPath string, fi os.FileInfo, r io.Reader
 	if err != nil {
 		return err
 	}
+	setExtra(fh, fi)
 	fh.Name = filepath.Join(relPath, fi.Name())
+	fh.Method = s.method(fh.Name, fi.Size())
 
-	fw, err := s.Writer.CreateHeader(fh)
+	fw, err := s.create(s.Writer, fh)
 	if err != nil {
 		return err
+	} else if _, err = io.Copy(fw, r); err != nil {
+		return err
 	}
-	_, err = io.Copy(fw, r)
-	return err
+	return fw.Close()
 }
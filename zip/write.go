@@ -17,11 +17,15 @@ package zip
 import (
 	"archive/zip"
 	"fmt"
+	"hash/crc32"
 	"io"
+	"io/fs"
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/unknwon/cae"
 )
@@ -29,12 +33,49 @@ import (
 // Switcher of printing trace information when pack and extract.
 var Verbose = true
 
-// extractFile extracts zip.File to file system.
-func extractFile(f *zip.File, destPath string) error {
-	filePath := path.Join(destPath, f.Name)
+// open returns a reader of content of zip.File, encrypted entries are
+// decrypted with Password.
+func (z *ZipArchive) open(f *zip.File) (io.ReadCloser, error) {
+	if f.Flags&flagEncrypted == 0 {
+		return f.Open()
+	}
+	raw, err := f.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+	return openEncrypted(f, raw, z.Password)
+}
+
+// openLimited is like open but enforces limits of Limiter, compressed
+// content is decompressed here so that bytes actually read from archive
+// are counted for the ratio limit.
+func (z *ZipArchive) openLimited(f *zip.File, l *cae.Limiter) (io.ReadCloser, error) {
+	raw, err := f.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+	return l.EntryReader(f.Name, raw, func(r io.Reader) (io.ReadCloser, error) {
+		if f.Flags&flagEncrypted != 0 {
+			return openEncrypted(f, r, z.Password)
+		}
+		dcomp := decompressor(f.Method)
+		if dcomp == nil {
+			return nil, zip.ErrAlgorithm
+		}
+		return &checksumReader{
+			rc:    dcomp(r),
+			crc:   crc32.NewIEEE(),
+			f:     f,
+			check: true,
+		}, nil
+	})
+}
+
+// extractFile extracts zip.File to given path of file system.
+func (z *ZipArchive) extractFile(f *zip.File, filePath string, l *cae.Limiter) error {
 	os.MkdirAll(path.Dir(filePath), os.ModePerm)
 
-	rc, err := f.Open()
+	rc, err := z.openLimited(f, l)
 	if err != nil {
 		return err
 	}
@@ -50,17 +91,56 @@ func extractFile(f *zip.File, destPath string) error {
 		return err
 	}
 
-	// Skip symbolic links.
-	if f.FileInfo().Mode()&os.ModeSymlink != 0 {
-		return nil
-	}
 	// Set back file information.
-	if err = os.Chtimes(filePath, f.ModTime(), f.ModTime()); err != nil {
+	mtime, atime := fileTimes(f)
+	if err = os.Chtimes(filePath, atime, mtime); err != nil {
+		return err
+	} else if err = z.chown(f, filePath); err != nil {
 		return err
 	}
 	return os.Chmod(filePath, f.FileInfo().Mode())
 }
 
+// chown changes owner of extracted file to the one stored in extra field
+// of zip.File, if any.
+func (z *ZipArchive) chown(f *zip.File, filePath string) error {
+	if uid, gid, ok := fileOwnerExtra(f); ok {
+		return cae.Chown(z.ExtractOptions, filePath, uid, gid)
+	}
+	return nil
+}
+
+// maxLinkSize is the maximum length of symbolic link target.
+const maxLinkSize = 4096
+
+// extractSymlink creates symbolic link from zip.File to given path
+// of file system, the link target is stored as content of entry.
+func (z *ZipArchive) extractSymlink(f *zip.File, destPath, filePath string, l *cae.Limiter) error {
+	rc, err := z.openLimited(f, l)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	target, err := ioutil.ReadAll(io.LimitReader(rc, maxLinkSize+1))
+	if err != nil {
+		return err
+	} else if len(target) > maxLinkSize {
+		return fmt.Errorf("target of symbolic link %q is too long", f.Name)
+	}
+	if !z.Insecure {
+		if err = cae.CheckSymlink(destPath, f.Name, string(target)); err != nil {
+			return err
+		}
+	}
+
+	os.MkdirAll(path.Dir(filePath), os.ModePerm)
+	if err = cae.Symlink(string(target), filePath); err != nil {
+		return err
+	}
+	return z.chown(f, filePath)
+}
+
 var defaultExtractFunc = func(fullName string, fi os.FileInfo) error {
 	if !Verbose {
 		return nil
@@ -75,48 +155,66 @@ var defaultExtractFunc = func(fullName string, fi os.FileInfo) error {
 // It accepts a function as a middleware for custom operations.
 func (z *ZipArchive) ExtractToFunc(destPath string, fn cae.HookFunc, entries ...string) (err error) {
 	destPath = strings.Replace(destPath, "\\", "/", -1)
-	isHasEntry := len(entries) > 0
+	m := cae.EntryMatcher(z.ExtractOptions, entries...)
 	if Verbose {
 		fmt.Println("Unzipping " + z.FileName + "...")
 	}
 	os.MkdirAll(destPath, os.ModePerm)
+	l := cae.NewLimiter(z.ExtractOptions)
+	type dir struct {
+		f    *zip.File
+		path string
+	}
+	var dirs []dir
 	for _, f := range z.File {
 		isDir := strings.HasSuffix(f.Name, "/")
-		f.Name = cae.Clean(strings.ReplaceAll(f.Name, "\\", "/"))
+		name := cae.Clean(strings.ReplaceAll(f.Name, "\\", "/"))
+		if !cae.Matches(m, name, isDir) {
+			continue
+		}
+
+		filePath := path.Join(destPath, name)
+		if !z.Insecure {
+			if filePath, err = cae.SecureJoin(destPath, f.Name); err != nil {
+				return err
+			}
+		}
+		f.Name = name
+
+		if err = fn(f.Name, f.FileInfo()); err != nil {
+			continue
+		} else if err = l.Entry(f.Name); err != nil {
+			return err
+		}
 
 		// Directory.
 		if isDir {
-			if isHasEntry {
-				if cae.IsEntry(f.Name, entries) {
-					if err = fn(f.Name, f.FileInfo()); err != nil {
-						continue
-					}
-					os.MkdirAll(path.Join(destPath, f.Name), os.ModePerm)
-				}
-				continue
-			}
-			if err = fn(f.Name, f.FileInfo()); err != nil {
-				continue
+			os.MkdirAll(filePath, os.ModePerm)
+			dirs = append(dirs, dir{f, filePath})
+			continue
+		}
+
+		// Symbolic link.
+		if f.Mode()&os.ModeSymlink != 0 {
+			if err = z.extractSymlink(f, destPath, filePath, l); err != nil {
+				return err
 			}
-			os.MkdirAll(path.Join(destPath, f.Name), os.ModePerm)
 			continue
 		}
 
 		// File.
-		if isHasEntry {
-			if cae.IsEntry(f.Name, entries) {
-				if err = fn(f.Name, f.FileInfo()); err != nil {
-					continue
-				}
-				err = extractFile(f, destPath)
-			}
-		} else {
-			if err = fn(f.Name, f.FileInfo()); err != nil {
-				continue
-			}
-			err = extractFile(f, destPath)
+		if err = z.extractFile(f, filePath, l); err != nil {
+			return err
 		}
-		if err != nil {
+	}
+
+	// Set back directory information after all children are written,
+	// in reverse order so that parents are updated after subdirectories.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		mtime, atime := fileTimes(dirs[i].f)
+		if err = os.Chtimes(dirs[i].path, atime, mtime); err != nil {
+			return err
+		} else if err = z.chown(dirs[i].f, dirs[i].path); err != nil {
 			return err
 		}
 	}
@@ -142,108 +240,215 @@ func (z *ZipArchive) ExtractTo(destPath string, entries ...string) (err error) {
 	return z.ExtractToFunc(destPath, defaultExtractFunc, entries...)
 }
 
+// SetExtractOptions replaces options for extracting, e.g. limits against
+// decompression bombs, of ZipArchive.
+func (z *ZipArchive) SetExtractOptions(opts cae.ExtractOptions) {
+	z.ExtractOptions = opts
+}
+
 // ExtractTo extracts given archive or the given files to the
 // specified destination.
 func ExtractTo(srcPath, destPath string, entries ...string) (err error) {
 	return ExtractToFunc(srcPath, destPath, defaultExtractFunc, entries...)
 }
 
-// extractFile extracts file from ZipArchive to file system.
-func (z *ZipArchive) extractFile(f *File) error {
-	if !z.isHasWriter {
-		for _, zf := range z.ReadCloser.File {
-			if f.Name == zf.Name {
-				return extractFile(zf, path.Dir(f.tmpPath))
+// writeFile writes an entry of ZipArchive to zip.Writer. Entries from
+// original archive are copied as they are without recompression.
+func writeFile(zw *zip.Writer, f *File, opts PackOptions) error {
+	if f.zf != nil {
+		fh := *f.FileHeader
+		if len(f.oldName) > 0 {
+			// Unicode Path extra field of the old name must not be kept.
+			fh.Extra = removeExtra(fh.Extra, unicodePathExtraID)
+			fh.Flags &^= 0x800
+			if err := encodeName(&fh, opts.NameEncoder); err != nil {
+				return err
 			}
 		}
+		w, err := zw.CreateRaw(&fh)
+		if err != nil {
+			return err
+		}
+		r, err := f.zf.OpenRaw()
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, r)
+		return err
 	}
-	return cae.Copy(f.tmpPath, f.absPath)
+
+	// File added by AddBytes or AddReader.
+	if f.open != nil {
+		fh := *f.FileHeader
+		fh.Method = opts.method(fh.Name, int64(fh.UncompressedSize64))
+		setTimes(&fh, fh.Modified, time.Time{})
+		return writeSource(zw, f, &fh, opts)
+	}
+
+	// Directory added by AddEmptyDir.
+	if len(f.absPath) == 0 {
+		fh := *f.FileHeader
+		fh.Method = zip.Store
+		fw, err := opts.create(zw, &fh)
+		if err != nil {
+			return err
+		}
+		return fw.Close()
+	}
+
+	fi, err := os.Lstat(f.absPath)
+	if err != nil {
+		return err
+	}
+	fh, err := fileHeader(strings.TrimSuffix(f.Name, "/"), fi, opts)
+	if err != nil {
+		return err
+	}
+	fh.Comment = f.Comment
+	return packEntry(f.absPath, fh, zw, fi, opts)
 }
 
-// Flush saves changes to original zip file if any.
-func (z *ZipArchive) Flush() error {
-	if !z.isHasChanged || (z.ReadCloser == nil && !z.isHasWriter) {
-		return nil
+// writeSource writes content of entry added by AddBytes or AddReader
+// to zip.Writer.
+func writeSource(zw *zip.Writer, f *File, fh *zip.FileHeader, opts PackOptions) error {
+	r, err := f.open()
+	if err != nil {
+		return err
 	}
+	defer r.Close()
 
-	// Extract to tmp path and pack back.
-	tmpPath := filepath.Join(os.TempDir(), "cae", filepath.Base(z.FileName))
-	_ = os.RemoveAll(tmpPath)
-	defer func() { _ = os.RemoveAll(tmpPath) }()
+	fw, err := opts.create(zw, fh)
+	if err != nil {
+		return err
+	}
+	if _, err = io.CopyN(fw, r, int64(f.UncompressedSize64)); err == io.EOF {
+		return io.ErrUnexpectedEOF
+	} else if err != nil {
+		return err
+	}
+	return fw.Close()
+}
 
+// writeTo writes all entries of ZipArchive to io.Writer.
+func (z *ZipArchive) writeTo(w io.Writer) error {
+	zw := z.PackOptions.newWriter(w)
+	if err := zw.SetComment(z.Comment); err != nil {
+		return err
+	}
 	for _, f := range z.files {
-		if strings.HasSuffix(f.Name, "/") {
-			_ = os.MkdirAll(filepath.Join(tmpPath, f.Name), os.ModePerm)
-			continue
+		if err := writeFile(zw, f, z.PackOptions); err != nil {
+			return err
 		}
+	}
+	return zw.Close()
+}
 
-		// Relative path inside zip temporary changed.
-		f.tmpPath = filepath.Join(tmpPath, f.Name)
-		if err := z.extractFile(f); err != nil {
+// Flush saves changes to original zip file if any.
+// Changes are written to a temporary file next to the original one,
+// which then replaces the original file.
+func (z *ZipArchive) Flush() error {
+	if !z.isHasChanged || (z.ReadCloser == nil && !z.isHasWriter) {
+		return nil
+	}
+
+	if z.isHasWriter {
+		if err := z.writeTo(z.writer); err != nil {
 			return err
 		}
+		z.isHasChanged = false
+		return nil
 	}
 
-	if z.isHasWriter {
-		return packToWriter(tmpPath, z.writer, defaultPackFunc, true)
+	fi, err := os.Stat(z.FileName)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(z.FileName), "."+filepath.Base(z.FileName)+".*.tmp")
+	if err != nil {
+		return err
 	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
 
-	if err := PackTo(tmpPath, z.FileName); err != nil {
+	if err = z.writeTo(tmp); err != nil {
+		tmp.Close()
+		return err
+	} else if err = tmp.Chmod(fi.Mode()); err != nil {
+		tmp.Close()
+		return err
+	} else if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	// Original file must be closed before replacing on some platforms.
+	if err = z.ReadCloser.Close(); err != nil {
+		return err
+	}
+	z.ReadCloser = nil
+	if err = os.Rename(tmpPath, z.FileName); err != nil {
 		return err
 	}
 	return z.Open(z.FileName, os.O_RDWR|os.O_TRUNC, z.Permission)
 }
 
-// packFile packs a file or directory to zip.Writer.
-func packFile(srcFile string, recPath string, zw *zip.Writer, fi os.FileInfo) error {
+// fileHeader returns the header of a file or directory to be packed.
+func fileHeader(recPath string, fi os.FileInfo, opts PackOptions) (*zip.FileHeader, error) {
+	fh, err := zip.FileInfoHeader(fi)
+	if err != nil {
+		return nil, err
+	}
+	setExtra(fh, fi)
+
 	if fi.IsDir() {
-		fh, err := zip.FileInfoHeader(fi)
+		fh.Name = recPath + "/"
+	} else {
+		fh.Name = recPath
+		fh.Method = opts.method(recPath, fi.Size())
+	}
+	return fh, nil
+}
+
+// packEntry packs a file or directory with given header to zip.Writer.
+func packEntry(srcFile string, fh *zip.FileHeader, zw *zip.Writer, fi os.FileInfo, opts PackOptions) error {
+	fw, err := opts.create(zw, fh)
+	if err != nil {
+		return err
+	}
+
+	if fi.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(srcFile)
 		if err != nil {
 			return err
 		}
-		fh.Name = recPath + "/"
-		if _, err = zw.CreateHeader(fh); err != nil {
+		if _, err = fw.Write([]byte(target)); err != nil {
 			return err
 		}
-	} else {
-		fh, err := zip.FileInfoHeader(fi)
+	} else if fi.Mode().IsRegular() {
+		f, err := os.Open(srcFile)
 		if err != nil {
 			return err
 		}
-		fh.Name = recPath
-		fh.Method = zip.Deflate
+		defer f.Close()
 
-		fw, err := zw.CreateHeader(fh)
-		if err != nil {
+		if _, err = io.Copy(fw, f); err != nil {
 			return err
 		}
+	}
+	return fw.Close()
+}
 
-		if fi.Mode()&os.ModeSymlink != 0 {
-			target, err := os.Readlink(srcFile)
-			if err != nil {
-				return err
-			}
-			if _, err = fw.Write([]byte(target)); err != nil {
-				return err
-			}
-		} else {
-			f, err := os.Open(srcFile)
-			if err != nil {
-				return err
-			}
-			defer f.Close()
-
-			if _, err = io.Copy(fw, f); err != nil {
-				return err
-			}
-		}
+// packFile packs a file or directory to zip.Writer.
+func packFile(srcFile string, recPath string, zw *zip.Writer, fi os.FileInfo, opts PackOptions) error {
+	fh, err := fileHeader(recPath, fi, opts)
+	if err != nil {
+		return err
 	}
-	return nil
+	return packEntry(srcFile, fh, zw, fi, opts)
 }
 
 // packDir packs a directory and its subdirectories and files
 // recursively to zip.Writer.
-func packDir(srcPath string, recPath string, zw *zip.Writer, fn cae.HookFunc) error {
+func packDir(srcPath string, recPath string, zw *zip.Writer, fn cae.HookFunc, opts PackOptions) error {
 	dir, err := os.Open(srcPath)
 	if err != nil {
 		return err
@@ -260,17 +465,24 @@ func packDir(srcPath string, recPath string, zw *zip.Writer, fn cae.HookFunc) er
 		}
 		curPath := srcPath + "/" + fi.Name()
 		tmpRecPath := filepath.Join(recPath, fi.Name())
-		if err = fn(curPath, fi); err != nil {
+		name := filepath.ToSlash(tmpRecPath)
+		if fi.IsDir() && cae.SkipDir(opts.PackMatcher, name) {
+			continue
+		} else if !fi.IsDir() && !cae.Matches(opts.PackMatcher, name, false) {
+			continue
+		} else if err = fn(curPath, fi); err != nil {
 			continue
 		}
 
 		if fi.IsDir() {
-			if err = packFile(srcPath, tmpRecPath, zw, fi); err != nil {
-				return err
+			if cae.Matches(opts.PackMatcher, name, true) {
+				if err = packFile(srcPath, tmpRecPath, zw, fi, opts); err != nil {
+					return err
+				}
 			}
-			err = packDir(curPath, tmpRecPath, zw, fn)
+			err = packDir(curPath, tmpRecPath, zw, fn, opts)
 		} else {
-			err = packFile(curPath, tmpRecPath, zw, fi)
+			err = packFile(curPath, tmpRecPath, zw, fi, opts)
 		}
 		if err != nil {
 			return err
@@ -280,44 +492,90 @@ func packDir(srcPath string, recPath string, zw *zip.Writer, fn cae.HookFunc) er
 }
 
 // packToWriter packs given path object to io.Writer.
-func packToWriter(srcPath string, w io.Writer, fn func(fullName string, fi os.FileInfo) error, includeDir bool) error {
-	zw := zip.NewWriter(w)
-	defer zw.Close()
-
-	f, err := os.Open(srcPath)
+func packToWriter(srcPath string, w io.Writer, fn func(fullName string, fi os.FileInfo) error, includeDir bool, opts PackOptions) (err error) {
+	fi, err := os.Stat(srcPath)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	fi, err := f.Stat()
-	if err != nil {
-		return err
-	}
+	zw := opts.newWriter(w)
+	defer func() {
+		if cerr := zw.Close(); err == nil {
+			err = cerr
+		}
+	}()
 
 	basePath := filepath.Base(srcPath)
 	if fi.IsDir() {
 		if includeDir {
-			if err = packFile(srcPath, basePath, zw, fi); err != nil {
-				return err
+			if cae.Matches(opts.PackMatcher, basePath, true) {
+				if err = packFile(srcPath, basePath, zw, fi, opts); err != nil {
+					return err
+				}
 			}
 		} else {
 			basePath = ""
 		}
-		return packDir(srcPath, basePath, zw, fn)
+		return packDir(srcPath, basePath, zw, fn, opts)
 	}
-	return packFile(srcPath, basePath, zw, fi)
+	return packFile(srcPath, basePath, zw, fi, opts)
 }
 
 // packTo packs given source path object to target path.
-func packTo(srcPath, destPath string, fn cae.HookFunc, includeDir bool) error {
+func packTo(srcPath, destPath string, fn cae.HookFunc, includeDir bool, opts PackOptions) error {
 	fw, err := os.Create(destPath)
 	if err != nil {
 		return err
 	}
 	defer fw.Close()
 
-	return packToWriter(srcPath, fw, fn, includeDir)
+	return packToWriter(srcPath, fw, fn, includeDir, opts)
+}
+
+// packFSFile packs a file or directory of fs.FS to zip.Writer.
+func packFSFile(fsys fs.FS, name, recPath string, zw *zip.Writer, fi fs.FileInfo, opts PackOptions) error {
+	fh, err := fileHeader(recPath, fi, opts)
+	if err != nil {
+		return err
+	}
+	fw, err := opts.create(zw, fh)
+	if err != nil {
+		return err
+	}
+
+	if !fi.IsDir() {
+		f, err := fsys.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if _, err = io.Copy(fw, f); err != nil {
+			return err
+		}
+	}
+	return fw.Close()
+}
+
+// PackFS packs the file tree rooted at root of fsys to io.Writer with given
+// options, e.g. an embed.FS. Entries are named by paths relative to root,
+// use "." to pack the whole file system.
+func PackFS(fsys fs.FS, root string, w io.Writer, opts PackOptions) (err error) {
+	zw := opts.newWriter(w)
+	defer func() {
+		if cerr := zw.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	return cae.WalkFS(fsys, root, func(name, relPath string, fi fs.FileInfo) error {
+		if fi.IsDir() && cae.SkipDir(opts.PackMatcher, relPath) {
+			return fs.SkipDir
+		} else if !cae.Matches(opts.PackMatcher, relPath, fi.IsDir()) {
+			return nil
+		}
+		return packFSFile(fsys, name, relPath, zw, fi, opts)
+	})
 }
 
 // PackToFunc packs the complete archive to the specified destination.
@@ -327,7 +585,17 @@ func PackToFunc(srcPath, destPath string, fn func(fullName string, fi os.FileInf
 	if len(includeDir) > 0 && includeDir[0] {
 		isIncludeDir = true
 	}
-	return packTo(srcPath, destPath, fn, isIncludeDir)
+	return packTo(srcPath, destPath, fn, isIncludeDir, PackOptions{})
+}
+
+// PackToWithOptions packs the complete archive to the specified destination
+// with given options.
+func PackToWithOptions(srcPath, destPath string, opts PackOptions, includeDir ...bool) error {
+	isIncludeDir := false
+	if len(includeDir) > 0 && includeDir[0] {
+		isIncludeDir = true
+	}
+	return packTo(srcPath, destPath, defaultPackFunc, isIncludeDir, opts)
 }
 
 var defaultPackFunc = func(fullName string, fi os.FileInfo) error {
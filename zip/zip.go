@@ -13,15 +13,21 @@
 // under the License.
 
 // Package zip enables you to transparently read or write ZIP compressed archives and the files inside them.
+// Compressions other than Store and Deflate are supported by RegisterCompressor
+// and RegisterDecompressor, e.g. Zstd, and Bzip2 can be read out of the box.
 package zip
 
 import (
 	"archive/zip"
+	"bytes"
 	"errors"
 	"io"
+	"io/fs"
+	"io/ioutil"
 	"os"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/unknwon/cae"
 )
@@ -29,10 +35,14 @@ import (
 // A File represents a file or directory entry in archive.
 type File struct {
 	*zip.FileHeader
-	oldName    string // NOTE: unused, for future change name feature.
-	oldComment string // NOTE: unused, for future change comment feature.
-	absPath    string // Absolute path of local file system.
-	tmpPath    string
+	oldName string    // Name before renaming, empty if not renamed.
+	absPath string    // Absolute path of local file system.
+	zf      *zip.File // Original entry in archive, nil if added later.
+
+	// open opens the content of entry added by AddBytes or AddReader.
+	open func() (io.ReadCloser, error)
+	// isStream indicates the content can only be read once when flushing.
+	isStream bool
 }
 
 // A ZipArchive represents a file archive, compressed with Zip.
@@ -44,14 +54,47 @@ type ZipArchive struct {
 	Flag       int
 	Permission os.FileMode
 
+	// NameDecoder decodes names of entries which are not flagged as UTF-8,
+	// e.g. DecodeGBK or DetectName, it must be set before calling Open.
+	// Names are kept as they are if nil, unless Unicode Path extra field
+	// is present.
+	NameDecoder NameDecoder
+
+	// Options for extracting, the zero value is safe for untrusted archives.
+	cae.ExtractOptions
+	// Options for packing new entries when flushing changes,
+	// Password is used for decrypting entries as well.
+	PackOptions
+
 	files        []*File
 	isHasChanged bool
+	view         cae.FS // Built on first call of View.
 
 	// For supporting flushing to io.Writer.
 	writer      io.Writer
 	isHasWriter bool
 }
 
+var _ cae.Archive = (*ZipArchive)(nil)
+
+func init() {
+	cae.RegisterFormat(cae.Format{
+		Name: "zip",
+		Match: func(header []byte) bool {
+			// Local file header or end of central directory of an empty archive.
+			return bytes.HasPrefix(header, []byte("PK\x03\x04")) ||
+				bytes.HasPrefix(header, []byte("PK\x05\x06"))
+		},
+		Open: func(name string) (cae.Archive, error) {
+			z, err := Open(name)
+			if err != nil {
+				return nil, err
+			}
+			return z, nil
+		},
+	})
+}
+
 // OpenFile is the generalized open call; most users will use Open
 // instead. It opens the named zip file with specified flag
 // (O_RDONLY etc.) if applicable. If successful,
@@ -104,6 +147,95 @@ func (z *ZipArchive) List(prefixes ...string) []string {
 	return names
 }
 
+// ListMatch returns a string slice of files' name in ZipArchive
+// which are matched by m.
+func (z *ZipArchive) ListMatch(m cae.Matcher) []string {
+	names := make([]string, 0, z.NumFiles)
+	for _, f := range z.files {
+		if cae.Matches(m, f.Name, false) {
+			names = append(names, f.Name)
+		}
+	}
+	return names
+}
+
+// entry returns metadata of an entry, the target of symbolic link is
+// read from its content.
+func (z *ZipArchive) entry(f *File) (cae.Entry, error) {
+	e := cae.Entry{
+		Name:           f.Name,
+		Size:           int64(f.UncompressedSize64),
+		CompressedSize: -1,
+		Mode:           f.Mode(),
+		ModTime:        f.Modified,
+	}
+	if e.ModTime.IsZero() {
+		e.ModTime = f.ModTime()
+	}
+	switch {
+	case strings.HasSuffix(f.Name, "/"):
+		e.Type = cae.TypeDir
+	case e.Mode&os.ModeSymlink != 0:
+		e.Type = cae.TypeSymlink
+	case e.Mode.IsRegular():
+		e.Type = cae.TypeFile
+	default:
+		e.Type = cae.TypeOther
+	}
+
+	if f.zf != nil {
+		e.CompressedSize = int64(f.CompressedSize64)
+		e.Method = methodName(f.Method, f.Extra)
+		e.CRC32 = f.CRC32
+		e.ModTime, _ = fileTimes(f.zf)
+		e.Uid, e.Gid, _ = fileOwnerExtra(f.zf)
+	}
+
+	if e.Type == cae.TypeSymlink {
+		rc, err := z.openFile(f)
+		if err != nil {
+			return e, err
+		}
+		defer rc.Close()
+		target, err := ioutil.ReadAll(io.LimitReader(rc, maxLinkSize))
+		if err != nil {
+			return e, err
+		}
+		e.LinkTarget = string(target)
+	}
+	return e, nil
+}
+
+// ListEntries returns metadata of entries in ZipArchive, including ones
+// not flushed yet. Only entries accepted by filter are returned if it
+// is not nil.
+func (z *ZipArchive) ListEntries(filter cae.EntryFilter) ([]cae.Entry, error) {
+	entries := make([]cae.Entry, 0, z.NumFiles)
+	for _, f := range z.files {
+		e, err := z.entry(f)
+		if err != nil {
+			return nil, err
+		} else if filter != nil && !filter(e) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// Stat returns metadata of entry with given name, the trailing slash
+// of directory name can be omitted.
+func (z *ZipArchive) Stat(name string) (cae.Entry, error) {
+	for _, candidate := range []string{name, name + "/"} {
+		for _, f := range z.files {
+			if f.Name == candidate {
+				return z.entry(f)
+			}
+		}
+	}
+	return cae.Entry{}, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+}
+
 // AddEmptyDir adds a raw directory entry to ZipArchive,
 // it returns false if same directory enry already existed.
 func (z *ZipArchive) AddEmptyDir(dirPath string) bool {
@@ -128,6 +260,7 @@ func (z *ZipArchive) AddEmptyDir(dirPath string) bool {
 		FileHeader: &zip.FileHeader{
 			Name:             dirPath + "/",
 			UncompressedSize: 0,
+			Modified:         time.Now(),
 		},
 	})
 	z.updateStat()
@@ -136,14 +269,32 @@ func (z *ZipArchive) AddEmptyDir(dirPath string) bool {
 
 // AddDir adds a directory and subdirectories entries to ZipArchive.
 func (z *ZipArchive) AddDir(dirPath, absPath string) error {
+	return z.addDir(dirPath, absPath, true)
+}
+
+// addDir adds entries of a directory and its subdirectories which are
+// matched by PackMatcher, the entry of directory itself is added if
+// isMatched is true.
+func (z *ZipArchive) addDir(dirPath, absPath string, isMatched bool) error {
 	dir, err := os.Open(absPath)
 	if err != nil {
 		return err
 	}
 	defer dir.Close()
 
-	// Make sure we have all upper level directories.
-	z.AddEmptyDir(dirPath)
+	if isMatched {
+		// Make sure we have all upper level directories.
+		z.AddEmptyDir(dirPath)
+
+		// Keep information of the directory itself if it is added just now.
+		dirName := strings.TrimSuffix(strings.Replace(dirPath, "\\", "/", -1), "/") + "/"
+		for _, f := range z.files {
+			if f.Name == dirName && f.zf == nil {
+				f.absPath = absPath
+				break
+			}
+		}
+	}
 
 	fis, err := dir.Readdir(0)
 	if err != nil {
@@ -153,10 +304,13 @@ func (z *ZipArchive) AddDir(dirPath, absPath string) error {
 		curPath := absPath + "/" + fi.Name()
 		tmpRecPath := path.Join(dirPath, fi.Name())
 		if fi.IsDir() {
-			if err = z.AddDir(tmpRecPath, curPath); err != nil {
+			if cae.SkipDir(z.PackMatcher, tmpRecPath) {
+				continue
+			}
+			if err = z.addDir(tmpRecPath, curPath, cae.Matches(z.PackMatcher, tmpRecPath, true)); err != nil {
 				return err
 			}
-		} else {
+		} else if cae.Matches(z.PackMatcher, tmpRecPath, false) {
 			if err = z.AddFile(tmpRecPath, curPath); err != nil {
 				return err
 			}
@@ -165,6 +319,41 @@ func (z *ZipArchive) AddDir(dirPath, absPath string) error {
 	return nil
 }
 
+// AddFS adds the file tree rooted at root of fsys to ZipArchive under
+// dirPath, e.g. an embed.FS, use "" as dirPath to add entries at top
+// level. Contents of files are not read until changes are flushed.
+func (z *ZipArchive) AddFS(dirPath string, fsys fs.FS, root string) error {
+	dirPath = strings.Trim(strings.Replace(dirPath, "\\", "/", -1), "/")
+	if len(dirPath) > 0 {
+		z.AddEmptyDir(dirPath)
+	}
+
+	return cae.WalkFS(fsys, root, func(name, relPath string, fi fs.FileInfo) error {
+		recPath := path.Join(dirPath, relPath)
+		if fi.IsDir() && cae.SkipDir(z.PackMatcher, recPath) {
+			return fs.SkipDir
+		} else if !cae.Matches(z.PackMatcher, recPath, fi.IsDir()) {
+			return nil
+		}
+
+		fh, err := zip.FileInfoHeader(fi)
+		if err != nil {
+			return err
+		}
+		file := &File{FileHeader: fh}
+		file.Name = recPath
+		if fi.IsDir() {
+			file.Name += "/"
+		} else {
+			file.open = func() (io.ReadCloser, error) {
+				return fsys.Open(name)
+			}
+		}
+		z.addFile(file)
+		return nil
+	})
+}
+
 // updateStat should be called after every change for rebuilding statistic.
 func (z *ZipArchive) updateStat() {
 	z.NumFiles = len(z.files)
@@ -198,13 +387,21 @@ func (z *ZipArchive) AddFile(fileName, absPath string) error {
 	}
 	file.Name = fileName
 	file.absPath = absPath
+	z.addFile(file)
+	return nil
+}
 
-	z.AddEmptyDir(path.Dir(fileName))
+// addFile adds an entry to ZipArchive, or replaces the one with same name.
+// Upper level directories are added automatically.
+func (z *ZipArchive) addFile(file *File) {
+	if dir := path.Dir(strings.TrimSuffix(file.Name, "/")); dir != "." {
+		z.AddEmptyDir(dir)
+	}
 
 	isExist := false
-	for _, f := range z.files {
-		if fileName == f.Name {
-			f = file
+	for i, f := range z.files {
+		if file.Name == f.Name {
+			z.files[i] = file
 			isExist = true
 			break
 		}
@@ -214,16 +411,198 @@ func (z *ZipArchive) AddFile(fileName, absPath string) error {
 	}
 
 	z.updateStat()
+}
+
+// AddBytes adds a file entry to ZipArchive with given content, data must
+// not be modified until changes are flushed.
+func (z *ZipArchive) AddBytes(name string, data []byte, opts cae.EntryOptions) error {
+	name = strings.Replace(name, "\\", "/", -1)
+	if len(name) == 0 || strings.HasSuffix(name, "/") {
+		return errors.New("invalid file name")
+	}
+
+	fh := &zip.FileHeader{
+		Name:               name,
+		Modified:           opts.Time(),
+		UncompressedSize64: uint64(len(data)),
+	}
+	fh.SetMode(opts.Perm())
+	z.addFile(&File{
+		FileHeader: fh,
+		open: func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(data)), nil
+		},
+	})
+	return nil
+}
+
+// AddReader adds a file entry to ZipArchive with content of size bytes read
+// from r, which is not read until changes are flushed. The entry can be
+// opened before flushing only if r implements io.ReaderAt and io.Seeker,
+// content is then read from the current offset of r.
+func (z *ZipArchive) AddReader(name string, r io.Reader, size int64, opts cae.EntryOptions) error {
+	name = strings.Replace(name, "\\", "/", -1)
+	if len(name) == 0 || strings.HasSuffix(name, "/") {
+		return errors.New("invalid file name")
+	} else if size < 0 {
+		return errors.New("negative size")
+	}
+
+	fh := &zip.FileHeader{
+		Name:               name,
+		Modified:           opts.Time(),
+		UncompressedSize64: uint64(size),
+	}
+	fh.SetMode(opts.Perm())
+	f := &File{FileHeader: fh}
+	if ra, ok := r.(interface {
+		io.ReaderAt
+		io.Seeker
+	}); ok {
+		// Content can be read repeatedly from the current offset.
+		offset, err := ra.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		f.open = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(io.NewSectionReader(ra, offset, size)), nil
+		}
+	} else {
+		f.open = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(r), nil
+		}
+		f.isStream = true
+	}
+	z.addFile(f)
+	return nil
+}
+
+// rename changes name of an entry and records its original name.
+func (f *File) rename(name string) {
+	if len(f.oldName) == 0 {
+		f.oldName = f.Name
+	}
+	f.Name = name
+}
+
+// RenameEntry renames an entry, directory entry is renamed without
+// its children, see MoveDir for moving a directory. Upper level
+// directories of new name are added automatically.
+func (z *ZipArchive) RenameEntry(oldName, newName string) error {
+	oldName = strings.Replace(oldName, "\\", "/", -1)
+	newName = strings.Replace(newName, "\\", "/", -1)
+	if strings.HasSuffix(oldName, "/") != strings.HasSuffix(newName, "/") {
+		return errors.New("cannot rename between file and directory")
+	}
+
+	var entry *File
+	idx := 0
+	for i, f := range z.files {
+		if f.Name == newName {
+			return errors.New("entry with new name already exists")
+		} else if f.Name == oldName {
+			entry, idx = f, i
+		}
+	}
+	if entry == nil {
+		return errors.New("entry with given name not found")
+	}
+
+	entry.rename(newName)
+	n := len(z.files)
+	if dir := path.Dir(strings.TrimSuffix(newName, "/")); dir != "." {
+		z.AddEmptyDir(dir)
+	}
+	z.insertBefore(idx, n)
+	z.updateStat()
+	return nil
+}
+
+// MoveDir moves a directory and all its children to the new path.
+// Upper level directories of new path are added automatically.
+func (z *ZipArchive) MoveDir(oldPath, newPath string) error {
+	oldPath = strings.TrimSuffix(strings.Replace(oldPath, "\\", "/", -1), "/") + "/"
+	newPath = strings.TrimSuffix(strings.Replace(newPath, "\\", "/", -1), "/") + "/"
+	if oldPath == "/" || newPath == "/" {
+		return errors.New("invalid directory path")
+	} else if strings.HasPrefix(newPath, oldPath) {
+		return errors.New("cannot move directory into itself")
+	}
+
+	var entries []*File
+	idx := 0
+	for i, f := range z.files {
+		if strings.HasPrefix(f.Name, oldPath) {
+			if len(entries) == 0 {
+				idx = i
+			}
+			entries = append(entries, f)
+		} else if strings.HasPrefix(f.Name, newPath) {
+			return errors.New("entry with new path already exists")
+		}
+	}
+	if len(entries) == 0 {
+		return errors.New("entry with given path not found")
+	}
+
+	for _, f := range entries {
+		f.rename(newPath + strings.TrimPrefix(f.Name, oldPath))
+	}
+	n := len(z.files)
+	z.AddEmptyDir(newPath)
+	if dir := path.Dir(strings.TrimSuffix(newPath, "/")); dir != "." {
+		z.AddEmptyDir(dir)
+	}
+	z.insertBefore(idx, n)
+	z.updateStat()
+	return nil
+}
+
+// insertBefore moves entries appended after the first n ones in front of
+// the entry with given index, so that upper level directories added for
+// renamed entries come before them.
+func (z *ZipArchive) insertBefore(idx, n int) {
+	added := append([]*File(nil), z.files[n:]...)
+	copy(z.files[idx+len(added):], z.files[idx:n])
+	copy(z.files[idx:], added)
+}
+
+// uint16max is the maximum length of comments.
+const uint16max = (1 << 16) - 1
+
+// SetComment sets the comment of ZipArchive.
+func (z *ZipArchive) SetComment(comment string) error {
+	if len(comment) > uint16max {
+		return errors.New("comment too long")
+	}
+	z.Comment = comment
+	z.isHasChanged = true
 	return nil
 }
 
+// SetEntryComment sets the comment of an entry by its name.
+func (z *ZipArchive) SetEntryComment(name, comment string) error {
+	if len(comment) > uint16max {
+		return errors.New("comment too long")
+	}
+	for _, f := range z.files {
+		if f.Name == name {
+			f.Comment = comment
+			z.isHasChanged = true
+			return nil
+		}
+	}
+	return errors.New("entry with given name not found")
+}
+
 // DeleteIndex deletes an entry in the archive by its index.
 func (z *ZipArchive) DeleteIndex(idx int) error {
-	if idx >= z.NumFiles {
+	if idx < 0 || idx >= z.NumFiles {
 		return errors.New("index out of range of number of files")
 	}
 
 	z.files = append(z.files[:idx], z.files[idx+1:]...)
+	z.updateStat()
 	return nil
 }
 
@@ -15,13 +15,25 @@
 package zip
 
 import (
+	"archive/zip"
+	"bytes"
+	"compress/bzip2"
+	"compress/flate"
+	"errors"
 	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 	"github.com/unknwon/cae"
@@ -160,7 +172,7 @@ func TestExtractTo(t *testing.T) {
 			list, err := com.StatDir(path.Join(os.TempDir(), "testdata/test2"), true)
 			So(err, ShouldBeNil)
 			So(com.CompareSliceStrU(
-				list, strings.Split("dir/ dir/bar readonly", " ")), ShouldBeTrue)
+				list, strings.Split("dir/ dir/bar dir/empty/ readonly", " ")), ShouldBeTrue)
 		})
 	})
 }
@@ -298,3 +310,1199 @@ func TestCopy(t *testing.T) {
 		})
 	})
 }
+
+func TestFormat(t *testing.T) {
+	Convey("Open a zip file by detecting its format", t, func() {
+		a, err := cae.Open("testdata/test.zip")
+		So(err, ShouldBeNil)
+		So(a, ShouldHaveSameTypeAs, &ZipArchive{})
+		So(strings.Join(a.List(), " "), ShouldEqual,
+			"dir/ dir/bar dir/empty/ hello readonly")
+		So(a.Close(), ShouldBeNil)
+
+		Convey("Use a zip file through Archive", func() {
+			a, err := cae.Open("testdata/test.zip")
+			So(err, ShouldBeNil)
+			defer a.Close()
+
+			data, err := a.ReadFile("dir/bar")
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "foo \r\n")
+			p, err := fs.ReadFile(a.View(), "dir/bar")
+			So(err, ShouldBeNil)
+			So(string(p), ShouldEqual, "foo \r\n")
+			e, err := a.Stat("dir/bar")
+			So(err, ShouldBeNil)
+			So(e.Size, ShouldEqual, 6)
+			So(a.ListMatch(cae.Names("dir")), ShouldResemble, []string{"dir/", "dir/bar", "dir/empty/"})
+
+			a.SetExtractOptions(cae.ExtractOptions{MaxEntries: 1})
+			err = a.ExtractTo(path.Join(os.TempDir(), "testdata/TestFormat"))
+			So(err, ShouldHaveSameTypeAs, &cae.LimitError{})
+		})
+
+		Convey("Open a file that is not an archive", func() {
+			_, err := cae.Open("testdata/readme.notzip")
+			So(err, ShouldEqual, cae.ErrFormat)
+		})
+
+		Convey("Open a zip file from io.Reader", func() {
+			f, err := os.Open("testdata/test.zip")
+			So(err, ShouldBeNil)
+			defer f.Close()
+
+			a, err := cae.OpenReader(f)
+			So(err, ShouldBeNil)
+			So(a.List("h"), ShouldResemble, []string{"hello"})
+			So(a.Close(), ShouldBeNil)
+		})
+
+		Convey("Detect format from io.Reader", func() {
+			f, err := os.Open("testdata/test.zip")
+			So(err, ShouldBeNil)
+			defer f.Close()
+
+			name, err := cae.DetectFormat(f)
+			So(err, ShouldBeNil)
+			So(name, ShouldEqual, "zip")
+		})
+
+		Convey("Detect format from empty io.Reader", func() {
+			_, err := cae.DetectFormat(strings.NewReader(""))
+			So(err, ShouldEqual, cae.ErrFormat)
+		})
+	})
+}
+
+// writeZip writes a zip file with given entry names, entries have
+// their names as content.
+func writeZip(name string, entries ...string) error {
+	fw, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer fw.Close()
+
+	zw := zip.NewWriter(fw)
+	for _, entry := range entries {
+		w, err := zw.Create(entry)
+		if err != nil {
+			return err
+		}
+		if _, err = w.Write([]byte(entry)); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func TestExtractUnsafe(t *testing.T) {
+	Convey("Extract zip files with unsafe entries", t, func() {
+		name := path.Join(os.TempDir(), "testdata/TestExtractUnsafe.zip")
+		dest := path.Join(os.TempDir(), "testdata/TestExtractUnsafe")
+		os.RemoveAll(dest)
+
+		for _, entry := range []string{"../evil", "/evil", "dir/../../evil"} {
+			So(writeZip(name, entry), ShouldBeNil)
+			z, err := Open(name)
+			So(err, ShouldBeNil)
+
+			err = z.ExtractTo(dest)
+			So(err, ShouldHaveSameTypeAs, &cae.UnsafePathError{})
+			So(err.(*cae.UnsafePathError).Name, ShouldEqual, entry)
+			So(z.Close(), ShouldBeNil)
+		}
+
+		Convey("Extract through an existing symbolic link", func() {
+			So(os.MkdirAll(dest, os.ModePerm), ShouldBeNil)
+			So(os.Symlink(os.TempDir(), path.Join(dest, "link")), ShouldBeNil)
+			So(writeZip(name, "link/evil"), ShouldBeNil)
+			z, err := Open(name)
+			So(err, ShouldBeNil)
+			defer z.Close()
+
+			So(z.ExtractTo(dest), ShouldHaveSameTypeAs, &cae.UnsafePathError{})
+		})
+	})
+}
+
+func TestExtractLimits(t *testing.T) {
+	Convey("Extract a zip file with limits", t, func() {
+		dest := path.Join(os.TempDir(), "testdata/TestExtractLimits")
+		os.RemoveAll(dest)
+		z, err := Open("testdata/test.zip")
+		So(err, ShouldBeNil)
+
+		Convey("Exceed maximum number of entries", func() {
+			z.MaxEntries = 2
+			So(z.ExtractTo(dest), ShouldHaveSameTypeAs, &cae.LimitError{})
+		})
+
+		Convey("Exceed maximum total size", func() {
+			z.MaxTotalSize = 1
+			So(z.ExtractTo(dest), ShouldHaveSameTypeAs, &cae.LimitError{})
+		})
+
+		Convey("Exceed maximum ratio with padded compressed size", func() {
+			data := make([]byte, 1<<20)
+			var buf bytes.Buffer
+			fw, err := flate.NewWriter(&buf, flate.BestCompression)
+			So(err, ShouldBeNil)
+			_, err = fw.Write(data)
+			So(err, ShouldBeNil)
+			So(fw.Close(), ShouldBeNil)
+			// Padding makes the declared compressed size look harmless.
+			buf.Write(make([]byte, len(data)))
+
+			name := path.Join(os.TempDir(), "testdata/TestExtractLimits.zip")
+			f, err := os.Create(name)
+			So(err, ShouldBeNil)
+			zw := zip.NewWriter(f)
+			w, err := zw.CreateRaw(&zip.FileHeader{
+				Name:               "bomb",
+				Method:             zip.Deflate,
+				CRC32:              crc32.ChecksumIEEE(data),
+				CompressedSize64:   uint64(buf.Len()),
+				UncompressedSize64: uint64(len(data)),
+			})
+			So(err, ShouldBeNil)
+			_, err = w.Write(buf.Bytes())
+			So(err, ShouldBeNil)
+			So(zw.Close(), ShouldBeNil)
+			So(f.Close(), ShouldBeNil)
+
+			bomb, err := Open(name)
+			So(err, ShouldBeNil)
+			defer bomb.Close()
+			bomb.MaxRatio = 50
+			err = bomb.ExtractTo(dest)
+			So(err, ShouldHaveSameTypeAs, &cae.LimitError{})
+			So(err.(*cae.LimitError).Limit, ShouldEqual, "MaxRatio")
+		})
+
+		Convey("Within limits", func() {
+			z.MaxEntries = 5
+			z.MaxTotalSize = 1 << 20
+			So(z.ExtractTo(dest), ShouldBeNil)
+		})
+	})
+}
+
+func TestExtractSymlink(t *testing.T) {
+	Convey("Extract symbolic links from a zip file", t, func() {
+		src := path.Join(os.TempDir(), "testdata/TestExtractSymlink")
+		dest := path.Join(os.TempDir(), "testdata/TestExtractSymlink.out")
+		name := path.Join(os.TempDir(), "testdata/TestExtractSymlink.zip")
+		os.RemoveAll(src)
+		os.RemoveAll(dest)
+		So(os.MkdirAll(path.Join(src, "dir"), os.ModePerm), ShouldBeNil)
+		So(cae.Copy(path.Join(src, "dir/README.txt"), "testdata/README.txt"), ShouldBeNil)
+		So(os.Symlink("dir/README.txt", path.Join(src, "link")), ShouldBeNil)
+
+		Convey("Symbolic link inside destination", func() {
+			So(PackTo(src, name), ShouldBeNil)
+			So(ExtractTo(name, dest), ShouldBeNil)
+
+			target, err := os.Readlink(path.Join(dest, "link"))
+			So(err, ShouldBeNil)
+			So(target, ShouldEqual, "dir/README.txt")
+		})
+
+		Convey("Symbolic link outside destination", func() {
+			So(os.Symlink("../../../evil", path.Join(src, "dir/evil")), ShouldBeNil)
+			So(PackTo(src, name), ShouldBeNil)
+			So(ExtractTo(name, dest), ShouldHaveSameTypeAs, &cae.UnsafePathError{})
+		})
+
+		Convey("Extract a symbolic link with too long target", func() {
+			fw, err := os.Create(name)
+			So(err, ShouldBeNil)
+			zw := zip.NewWriter(fw)
+			fh := &zip.FileHeader{Name: "link"}
+			fh.SetMode(os.ModeSymlink | 0777)
+			w, err := zw.CreateHeader(fh)
+			So(err, ShouldBeNil)
+			_, err = w.Write([]byte(strings.Repeat("a/", maxLinkSize)))
+			So(err, ShouldBeNil)
+			So(zw.Close(), ShouldBeNil)
+			So(fw.Close(), ShouldBeNil)
+
+			So(ExtractTo(name, dest), ShouldNotBeNil)
+			_, err = os.Lstat(path.Join(dest, "link"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+	})
+}
+
+func TestOriginalHeaders(t *testing.T) {
+	Convey("Open a zip file and keep original headers", t, func() {
+		z, err := Open("testdata/test.zip")
+		So(err, ShouldBeNil)
+		defer z.Close()
+
+		for i, f := range z.files {
+			zf := z.ReadCloser.File[i]
+			So(f.zf, ShouldEqual, zf)
+			So(f.Method, ShouldEqual, zf.Method)
+			So(f.CRC32, ShouldEqual, zf.CRC32)
+			So(f.Modified, ShouldResemble, zf.Modified)
+			So(f.CreatorVersion, ShouldEqual, zf.CreatorVersion)
+			So(f.Extra, ShouldResemble, zf.Extra)
+			So(f.CompressedSize64, ShouldEqual, zf.CompressedSize64)
+		}
+	})
+}
+
+func TestFlushRawCopy(t *testing.T) {
+	Convey("Flush changes and keep untouched entries as they are", t, func() {
+		dir := filepath.Join(os.TempDir(), "testdata/TestFlushRawCopy")
+		os.RemoveAll(dir)
+		So(os.MkdirAll(dir, os.ModePerm), ShouldBeNil)
+		name := filepath.Join(dir, "test.zip")
+		So(com.Copy("testdata/test.zip", name), ShouldBeNil)
+
+		z, err := OpenFile(name, os.O_RDWR, 0)
+		So(err, ShouldBeNil)
+		So(z.AddFile("added/README.txt", "testdata/README.txt"), ShouldBeNil)
+		So(z.DeleteName("dir/"), ShouldBeNil)
+		So(z.Flush(), ShouldBeNil)
+		So(z.Close(), ShouldBeNil)
+
+		// No temporary file is left behind.
+		fis, err := os.ReadDir(dir)
+		So(err, ShouldBeNil)
+		So(len(fis), ShouldEqual, 1)
+
+		orig, err := zip.OpenReader("testdata/test.zip")
+		So(err, ShouldBeNil)
+		defer orig.Close()
+		flushed, err := zip.OpenReader(name)
+		So(err, ShouldBeNil)
+		defer flushed.Close()
+
+		rawBytes := func(f *zip.File) string {
+			r, err := f.OpenRaw()
+			So(err, ShouldBeNil)
+			p, err := ioutil.ReadAll(r)
+			So(err, ShouldBeNil)
+			return string(p)
+		}
+
+		files := make(map[string]*zip.File)
+		for _, f := range flushed.File {
+			files[f.Name] = f
+		}
+		So(files["dir/"], ShouldBeNil)
+		So(files["added/README.txt"], ShouldNotBeNil)
+		So(files["added/README.txt"].Method, ShouldEqual, zip.Deflate)
+
+		for _, f := range orig.File {
+			if f.Name == "dir/" {
+				continue
+			}
+			nf := files[f.Name]
+			So(nf, ShouldNotBeNil)
+			So(nf.Method, ShouldEqual, f.Method)
+			So(nf.CRC32, ShouldEqual, f.CRC32)
+			So(nf.Modified.Unix(), ShouldEqual, f.Modified.Unix())
+			So(nf.CompressedSize64, ShouldEqual, f.CompressedSize64)
+			So(rawBytes(nf), ShouldEqual, rawBytes(f))
+		}
+	})
+}
+
+func TestPackMethod(t *testing.T) {
+	Convey("Pick compression method by entry name", t, func() {
+		So(DefaultMethod("a/b.txt", 10), ShouldEqual, zip.Deflate)
+		So(DefaultMethod("a/b.PNG", 10), ShouldEqual, zip.Store)
+		So(DefaultMethod("b.tar.gz", 10), ShouldEqual, zip.Store)
+	})
+
+	Convey("Pack with default and custom method policy", t, func() {
+		dest := path.Join(os.TempDir(), "testdata/TestPackMethod.zip")
+		So(PackToWithOptions("testdata", dest, PackOptions{}), ShouldBeNil)
+
+		methods := func() map[string]uint16 {
+			zr, err := zip.OpenReader(dest)
+			So(err, ShouldBeNil)
+			defer zr.Close()
+			m := make(map[string]uint16)
+			for _, f := range zr.File {
+				m[f.Name] = f.Method
+			}
+			return m
+		}
+		m := methods()
+		So(m["README.txt"], ShouldEqual, zip.Deflate)
+		So(m["gophercolor16x16.png"], ShouldEqual, zip.Store)
+		So(m["test.zip"], ShouldEqual, zip.Store)
+
+		So(PackToWithOptions("testdata", dest, PackOptions{
+			Method: func(name string, size int64) uint16 { return zip.Store },
+		}), ShouldBeNil)
+		So(methods()["README.txt"], ShouldEqual, zip.Store)
+	})
+
+	Convey("Stream entries with method policy and deflate level", t, func() {
+		data := []byte(strings.Repeat("cae", 1<<14))
+		sizes := make([]uint64, 0, 2)
+		for _, level := range []int{0, flate.HuffmanOnly} {
+			buf := new(bytes.Buffer)
+			s := NewStreamArachive(buf)
+			s.Level = level
+
+			fi, err := os.Stat("testdata/README.txt")
+			So(err, ShouldBeNil)
+			So(s.StreamReader("", fi, bytes.NewReader(data)), ShouldBeNil)
+			fi, err = os.Stat("testdata/gophercolor16x16.png")
+			So(err, ShouldBeNil)
+			So(s.StreamReader("", fi, bytes.NewReader(data)), ShouldBeNil)
+			So(s.Close(), ShouldBeNil)
+
+			zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			So(err, ShouldBeNil)
+			So(zr.File[0].Method, ShouldEqual, zip.Deflate)
+			So(zr.File[1].Method, ShouldEqual, zip.Store)
+			sizes = append(sizes, zr.File[0].CompressedSize64)
+		}
+		So(sizes[0], ShouldBeLessThan, sizes[1])
+	})
+}
+
+func TestCompressors(t *testing.T) {
+	Convey("Extract an entry compressed with bzip2", t, func() {
+		compressed, err := ioutil.ReadFile("../tz/testdata/test.tar.bz2")
+		So(err, ShouldBeNil)
+		data, err := ioutil.ReadAll(bzip2.NewReader(bytes.NewReader(compressed)))
+		So(err, ShouldBeNil)
+
+		name := path.Join(os.TempDir(), "testdata/TestCompressors.zip")
+		fw, err := os.Create(name)
+		So(err, ShouldBeNil)
+		zw := zip.NewWriter(fw)
+		w, err := zw.CreateRaw(&zip.FileHeader{
+			Name:               "test.tar",
+			Method:             Bzip2,
+			CRC32:              crc32.ChecksumIEEE(data),
+			CompressedSize64:   uint64(len(compressed)),
+			UncompressedSize64: uint64(len(data)),
+		})
+		So(err, ShouldBeNil)
+		_, err = w.Write(compressed)
+		So(err, ShouldBeNil)
+		So(zw.Close(), ShouldBeNil)
+		So(fw.Close(), ShouldBeNil)
+
+		dest := path.Join(os.TempDir(), "testdata/TestCompressors")
+		os.RemoveAll(dest)
+		So(ExtractTo(name, dest), ShouldBeNil)
+		p, err := ioutil.ReadFile(path.Join(dest, "test.tar"))
+		So(err, ShouldBeNil)
+		So(p, ShouldResemble, data)
+	})
+
+	Convey("Pack and extract with registered compression", t, func() {
+		// Plug in a fake zstd codec based on flate.
+		RegisterCompressor(Zstd, func(w io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(w, flate.BestSpeed)
+		})
+		RegisterDecompressor(Zstd, flate.NewReader)
+		defer RegisterCompressor(Zstd, nil)
+		defer RegisterDecompressor(Zstd, nil)
+
+		name := path.Join(os.TempDir(), "testdata/TestCompressors.zip")
+		So(PackToWithOptions("testdata/README.txt", name, PackOptions{
+			Method: func(name string, size int64) uint16 { return Zstd },
+		}), ShouldBeNil)
+
+		z, err := Open(name)
+		So(err, ShouldBeNil)
+		defer z.Close()
+		So(z.File[0].Method, ShouldEqual, Zstd)
+
+		dest := path.Join(os.TempDir(), "testdata/TestCompressors")
+		os.RemoveAll(dest)
+		So(z.ExtractTo(dest), ShouldBeNil)
+		So(com.IsFile(path.Join(dest, "README.txt")), ShouldBeTrue)
+	})
+
+	Convey("Extract with unregistered compression", t, func() {
+		name := path.Join(os.TempDir(), "testdata/TestCompressors.zip")
+		So(PackToWithOptions("testdata/README.txt", name, PackOptions{
+			Method: func(name string, size int64) uint16 { return zip.Store },
+		}), ShouldBeNil)
+
+		z, err := Open(name)
+		So(err, ShouldBeNil)
+		defer z.Close()
+		z.File[0].Method = Xz
+		So(z.ExtractTo(path.Join(os.TempDir(), "testdata/TestCompressors")), ShouldEqual, zip.ErrAlgorithm)
+	})
+}
+
+func TestEncryption(t *testing.T) {
+	Convey("Derive keys by PBKDF2", t, func() {
+		// Test vectors from RFC 6070.
+		So(fmt.Sprintf("%x", pbkdf2([]byte("password"), []byte("salt"), 1, 20)), ShouldEqual,
+			"0c60c80f961f0e71f3a9b524af6012062fe037a6")
+		So(fmt.Sprintf("%x", pbkdf2([]byte("password"), []byte("salt"), 4096, 20)), ShouldEqual,
+			"4b007901b765489abead49d926f721d065a429c1")
+		So(fmt.Sprintf("%x", pbkdf2([]byte("passwordPASSWORDpassword"),
+			[]byte("saltSALTsaltSALTsaltSALTsaltSALTsalt"), 4096, 25)), ShouldEqual,
+			"3d2eec4fe41c849b80c8d83662c0e44a8b291a964cf2f07038")
+	})
+
+	Convey("Extract entries encrypted with ZipCrypto", t, func() {
+		dest := path.Join(os.TempDir(), "testdata/TestEncryption")
+		os.RemoveAll(dest)
+
+		z, err := Open("testdata/encrypted.zip")
+		So(err, ShouldBeNil)
+		defer z.Close()
+
+		err = z.ExtractTo(dest)
+		So(err, ShouldHaveSameTypeAs, &PasswordError{})
+
+		z.Password = "wrong"
+		So(z.ExtractTo(dest), ShouldHaveSameTypeAs, &PasswordError{})
+
+		z.Password = "cae-secret"
+		So(z.ExtractTo(dest), ShouldBeNil)
+		p, err := ioutil.ReadFile(path.Join(dest, "secret.txt"))
+		So(err, ShouldBeNil)
+		So(string(p), ShouldEqual, strings.Repeat("cae encrypted entry\n", 20))
+		p, err = ioutil.ReadFile(path.Join(dest, "gophercolor16x16.png"))
+		So(err, ShouldBeNil)
+		png, err := ioutil.ReadFile("testdata/gophercolor16x16.png")
+		So(err, ShouldBeNil)
+		So(p, ShouldResemble, png)
+	})
+
+	Convey("Pack and extract entries encrypted with AES-256", t, func() {
+		name := path.Join(os.TempDir(), "testdata/TestEncryption.zip")
+		dest := path.Join(os.TempDir(), "testdata/TestEncryption")
+		os.RemoveAll(dest)
+
+		So(PackToWithOptions("testdata/testdir", name, PackOptions{Password: "cae-secret"}), ShouldBeNil)
+
+		z, err := Open(name)
+		So(err, ShouldBeNil)
+		defer z.Close()
+		for _, f := range z.File {
+			if strings.HasSuffix(f.Name, "/") {
+				continue
+			}
+			So(f.Method, ShouldEqual, methodAES)
+			So(f.Flags&flagEncrypted, ShouldNotEqual, 0)
+			extra, ok := parseAESExtra(f.Extra)
+			So(ok, ShouldBeTrue)
+			So(extra.version, ShouldEqual, aesVersion2)
+			So(extra.strength, ShouldEqual, aesStrength)
+		}
+
+		z.Password = "wrong"
+		So(z.ExtractTo(dest), ShouldHaveSameTypeAs, &PasswordError{})
+
+		z.Password = "cae-secret"
+		So(z.ExtractTo(dest), ShouldBeNil)
+		So(com.IsFile(path.Join(dest, "gophercolor16x16.png")), ShouldBeTrue)
+		p, err := ioutil.ReadFile(path.Join(dest, "gophercolor16x16.png"))
+		So(err, ShouldBeNil)
+		png, err := ioutil.ReadFile("testdata/testdir/gophercolor16x16.png")
+		So(err, ShouldBeNil)
+		So(p, ShouldResemble, png)
+	})
+
+	Convey("Stream entries encrypted with AES-256 and detect tampering", t, func() {
+		buf := new(bytes.Buffer)
+		s := NewStreamArachive(buf)
+		s.Password = "cae-secret"
+		fi, err := os.Stat("testdata/README.txt")
+		So(err, ShouldBeNil)
+		data := strings.Repeat("cae", 100)
+		So(s.StreamReader("", fi, strings.NewReader(data)), ShouldBeNil)
+		So(s.Close(), ShouldBeNil)
+
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		So(err, ShouldBeNil)
+		So(zr.File[0].UncompressedSize64, ShouldEqual, len(data))
+		fr, err := zr.File[0].OpenRaw()
+		So(err, ShouldBeNil)
+		rc, err := openEncrypted(zr.File[0], fr, "cae-secret")
+		So(err, ShouldBeNil)
+		p, err := ioutil.ReadAll(rc)
+		So(err, ShouldBeNil)
+		So(string(p), ShouldEqual, data)
+
+		// Flip a byte of encrypted data.
+		raw := buf.Bytes()
+		offset, err := zr.File[0].DataOffset()
+		So(err, ShouldBeNil)
+		raw[offset+int64(4+4*aesStrength+aesPVLen)] ^= 0xff
+		zr, err = zip.NewReader(bytes.NewReader(raw), int64(len(raw)))
+		So(err, ShouldBeNil)
+		fr, err = zr.File[0].OpenRaw()
+		So(err, ShouldBeNil)
+		rc, err = openEncrypted(zr.File[0], fr, "cae-secret")
+		So(err, ShouldBeNil)
+		_, err = ioutil.ReadAll(rc)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestNameDecoder(t *testing.T) {
+	gbk := []byte{0xd6, 0xd0, 0xce, 0xc4, '.', 't', 'x', 't'}              // 中文.txt
+	sjis := []byte{0x83, 0x65, 0x83, 0x58, 0x83, 0x67, '.', 't', 'x', 't'} // テスト.txt
+	big5 := []byte{0xc1, 0x63, 0xc5, 0xe9, '.', 't', 'x', 't'}             // 繁體.txt
+	cp437 := []byte{'c', 'a', 'f', 0x82, '.', 't', 'x', 't'}               // café.txt
+	unicode := []byte{0xb2, 0xe2, 0xca, 0xd4, '.', 't', 'x', 't'}          // 测试.txt
+
+	name := path.Join(os.TempDir(), "testdata/TestNameDecoder.zip")
+	fw, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(fw)
+	for _, fh := range []*zip.FileHeader{
+		{Name: string(gbk), NonUTF8: true},
+		{Name: string(sjis), NonUTF8: true},
+		{Name: string(big5), NonUTF8: true},
+		{Name: string(cp437), NonUTF8: true},
+		{Name: string(unicode), NonUTF8: true, Extra: unicodePathExtra(unicode, "测试.txt")},
+		{Name: "utf8.txt"},
+	} {
+		if _, err = zw.CreateHeader(fh); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fw.Close()
+
+	open := func(dec NameDecoder) []string {
+		z := &ZipArchive{NameDecoder: dec}
+		So(z.Open(name, os.O_RDONLY, 0), ShouldBeNil)
+		defer z.Close()
+		return z.List()
+	}
+
+	Convey("Decode legacy names with given decoders", t, func() {
+		So(open(nil), ShouldResemble, []string{
+			string(gbk), string(sjis), string(big5), string(cp437), "测试.txt", "utf8.txt"})
+		So(open(DecodeGBK)[0], ShouldEqual, "中文.txt")
+		So(open(DecodeShiftJIS)[1], ShouldEqual, "テスト.txt")
+		So(open(DecodeBig5)[2], ShouldEqual, "繁體.txt")
+		So(open(DecodeCP437)[3], ShouldEqual, "café.txt")
+		So(open(DecodeCP437)[4], ShouldEqual, "测试.txt")
+	})
+
+	Convey("Detect charset of legacy names", t, func() {
+		names := open(DetectName)
+		So(names[0], ShouldEqual, "中文.txt")
+		So(names[1], ShouldEqual, "テスト.txt")
+		So(names[4], ShouldEqual, "测试.txt")
+		So(names[5], ShouldEqual, "utf8.txt")
+	})
+}
+
+func TestNameEncoder(t *testing.T) {
+	src := path.Join(os.TempDir(), "testdata/TestNameEncoder")
+	os.RemoveAll(src)
+	if err := os.MkdirAll(path.Join(src, "目录"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	} else if err = ioutil.WriteFile(path.Join(src, "目录/中文.txt"), []byte("cae"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	name := src + ".zip"
+
+	headers := func() map[string]zip.FileHeader {
+		zr, err := zip.OpenReader(name)
+		So(err, ShouldBeNil)
+		defer zr.Close()
+		fhs := make(map[string]zip.FileHeader)
+		for _, f := range zr.File {
+			fhs[f.Name] = f.FileHeader
+		}
+		return fhs
+	}
+
+	Convey("Set UTF-8 flag for non-ASCII names", t, func() {
+		for _, password := range []string{"", "cae-secret"} {
+			So(PackToWithOptions(src, name, PackOptions{Password: password}), ShouldBeNil)
+			fhs := headers()
+			So(fhs["目录/"].Flags&0x800, ShouldNotEqual, 0)
+			So(fhs["目录/中文.txt"].Flags&0x800, ShouldNotEqual, 0)
+		}
+	})
+
+	Convey("Store legacy names with Unicode Path extra field", t, func() {
+		So(PackToWithOptions(src, name, PackOptions{NameEncoder: EncodeGBK}), ShouldBeNil)
+		raw, err := EncodeGBK("目录/中文.txt")
+		So(err, ShouldBeNil)
+		fh, ok := headers()[string(raw)]
+		So(ok, ShouldBeTrue)
+		So(fh.Flags&0x800, ShouldEqual, 0)
+		unicode, ok := unicodePath(fh.Name, fh.Extra)
+		So(ok, ShouldBeTrue)
+		So(unicode, ShouldEqual, "目录/中文.txt")
+
+		z, err := Open(name)
+		So(err, ShouldBeNil)
+		defer z.Close()
+		So(z.List(), ShouldResemble, []string{"目录/", "目录/中文.txt"})
+	})
+
+	Convey("Rename legacy names with Unicode Path extra field", t, func() {
+		So(PackToWithOptions(src, name, PackOptions{NameEncoder: EncodeGBK}), ShouldBeNil)
+		z, err := OpenFile(name, os.O_RDWR, 0)
+		So(err, ShouldBeNil)
+		z.NameEncoder = EncodeGBK
+		So(z.RenameEntry("目录/中文.txt", "目录/新.txt"), ShouldBeNil)
+		So(z.Close(), ShouldBeNil)
+
+		raw, err := EncodeGBK("目录/新.txt")
+		So(err, ShouldBeNil)
+		fh, ok := headers()[string(raw)]
+		So(ok, ShouldBeTrue)
+		unicode, ok := unicodePath(fh.Name, fh.Extra)
+		So(ok, ShouldBeTrue)
+		So(unicode, ShouldEqual, "目录/新.txt")
+		// Only one Unicode Path extra field is stored.
+		So(len(fh.Extra)-len(removeExtra(fh.Extra, unicodePathExtraID)), ShouldEqual, 4+5+len(unicode))
+
+		z, err = Open(name)
+		So(err, ShouldBeNil)
+		defer z.Close()
+		So(z.List(), ShouldResemble, []string{"目录/", "目录/新.txt"})
+	})
+}
+
+func TestExtraFields(t *testing.T) {
+	Convey("Keep times and owner through extra fields", t, func() {
+		src := path.Join(os.TempDir(), "testdata/TestExtraFields")
+		dest := path.Join(os.TempDir(), "testdata/TestExtraFields.dest")
+		name := path.Join(os.TempDir(), "testdata/TestExtraFields.zip")
+		os.RemoveAll(src)
+		os.RemoveAll(dest)
+		os.Remove(name)
+
+		mtime := time.Date(2001, 2, 3, 4, 5, 7, 0, time.Local)
+		atime := time.Date(2002, 3, 4, 5, 6, 7, 0, time.Local)
+		dirTime := time.Date(2003, 4, 5, 6, 7, 8, 0, time.Local)
+		So(os.MkdirAll(path.Join(src, "dir"), os.ModePerm), ShouldBeNil)
+		So(ioutil.WriteFile(path.Join(src, "dir/file"), []byte("cae"), 0640), ShouldBeNil)
+		So(os.Chtimes(path.Join(src, "dir/file"), atime, mtime), ShouldBeNil)
+		So(os.Chtimes(path.Join(src, "dir"), dirTime, dirTime), ShouldBeNil)
+
+		z, err := Create(name)
+		So(err, ShouldBeNil)
+		So(z.AddDir("dir", path.Join(src, "dir")), ShouldBeNil)
+		So(z.Close(), ShouldBeNil)
+
+		z, err = Open(name)
+		So(err, ShouldBeNil)
+		defer z.Close()
+
+		for _, f := range z.File {
+			if runtime.GOOS != "windows" {
+				uid, gid, ok := fileOwnerExtra(f)
+				So(ok, ShouldBeTrue)
+				So(uid, ShouldEqual, os.Getuid())
+				So(gid, ShouldEqual, os.Getgid())
+			}
+			if f.Name == "dir/file" {
+				m, a := fileTimes(f)
+				So(m.Equal(mtime), ShouldBeTrue)
+				if runtime.GOOS == "linux" {
+					So(a.Equal(atime), ShouldBeTrue)
+				}
+			}
+		}
+
+		z.SameOwner = true
+		So(z.ExtractTo(dest), ShouldBeNil)
+		fi, err := os.Stat(path.Join(dest, "dir/file"))
+		So(err, ShouldBeNil)
+		So(fi.ModTime().Equal(mtime), ShouldBeTrue)
+		So(fi.Mode().Perm(), ShouldEqual, 0640)
+
+		// Directory is written before its children.
+		fi, err = os.Stat(path.Join(dest, "dir"))
+		So(err, ShouldBeNil)
+		So(fi.ModTime().Equal(dirTime), ShouldBeTrue)
+	})
+}
+
+func TestComment(t *testing.T) {
+	Convey("Set comments and flush to file system", t, func() {
+		name := path.Join(os.TempDir(), "testdata/TestComment.zip")
+		So(com.Copy("testdata/test.zip", name), ShouldBeNil)
+
+		z, err := OpenFile(name, os.O_RDWR, 0)
+		So(err, ShouldBeNil)
+		So(z.Comment, ShouldEqual, "This is the comment for test.zip")
+		So(z.SetComment("build 42"), ShouldBeNil)
+		So(z.SetEntryComment("hello", "greeting"), ShouldBeNil)
+		So(z.AddFile("README.txt", "testdata/README.txt"), ShouldBeNil)
+		So(z.SetEntryComment("README.txt", "readme"), ShouldBeNil)
+		So(z.SetEntryComment("404", "not found"), ShouldNotBeNil)
+		So(z.SetComment(strings.Repeat("x", 1<<16)), ShouldNotBeNil)
+		So(z.Close(), ShouldBeNil)
+
+		zr, err := zip.OpenReader(name)
+		So(err, ShouldBeNil)
+		defer zr.Close()
+		So(zr.Comment, ShouldEqual, "build 42")
+		comments := make(map[string]string)
+		for _, f := range zr.File {
+			comments[f.Name] = f.Comment
+		}
+		So(comments["hello"], ShouldEqual, "greeting")
+		So(comments["README.txt"], ShouldEqual, "readme")
+		So(comments["readonly"], ShouldEqual, "")
+	})
+
+	Convey("Set comments and flush to io.Writer", t, func() {
+		buf := new(bytes.Buffer)
+		z := New(buf)
+		So(z.SetComment("build 42"), ShouldBeNil)
+		So(z.AddFile("README.txt", "testdata/README.txt"), ShouldBeNil)
+		So(z.SetEntryComment("README.txt", "readme"), ShouldBeNil)
+		So(z.Flush(), ShouldBeNil)
+
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		So(err, ShouldBeNil)
+		So(zr.Comment, ShouldEqual, "build 42")
+		So(zr.File[0].Comment, ShouldEqual, "readme")
+	})
+}
+
+func TestRenameEntry(t *testing.T) {
+	Convey("Rename and move entries in archive", t, func() {
+		name := filepath.Join(os.TempDir(), "testdata/TestRenameEntry.zip")
+		z, err := Create(name)
+		So(err, ShouldBeNil)
+		So(z.AddFile("README.txt", "testdata/README.txt"), ShouldBeNil)
+		So(z.AddFile("dir/README.txt", "testdata/README.txt"), ShouldBeNil)
+		So(z.AddFile("dir/sub/README.txt", "testdata/README.txt"), ShouldBeNil)
+		So(z.Close(), ShouldBeNil)
+
+		z, err = OpenFile(name, os.O_RDWR, 0)
+		So(err, ShouldBeNil)
+
+		Convey("Rename with invalid arguments", func() {
+			So(z.RenameEntry("README.txt", "dir/"), ShouldNotBeNil)
+			So(z.RenameEntry("README.txt", "dir/README.txt"), ShouldNotBeNil)
+			So(z.RenameEntry("404.txt", "405.txt"), ShouldNotBeNil)
+			So(z.MoveDir("dir", "dir/sub/dir"), ShouldNotBeNil)
+			So(z.MoveDir("dir/sub", "dir"), ShouldNotBeNil)
+			So(z.MoveDir("404", "405"), ShouldNotBeNil)
+			So(z.MoveDir("dir", ""), ShouldNotBeNil)
+			So(z.Close(), ShouldBeNil)
+		})
+
+		Convey("Rename and move then flush", func() {
+			So(z.RenameEntry("README.txt", "doc/README.md"), ShouldBeNil)
+			So(z.MoveDir("dir", "a/b"), ShouldBeNil)
+			So(z.List(), ShouldResemble, []string{
+				"doc/", "doc/README.md", "a/", "a/b/", "a/b/README.txt",
+				"a/b/sub/", "a/b/sub/README.txt",
+			})
+			So(z.Close(), ShouldBeNil)
+
+			readme, err := ioutil.ReadFile("testdata/README.txt")
+			So(err, ShouldBeNil)
+			zr, err := zip.OpenReader(name)
+			So(err, ShouldBeNil)
+			defer zr.Close()
+
+			entries := make(map[string]string)
+			for _, f := range zr.File {
+				rc, err := f.Open()
+				So(err, ShouldBeNil)
+				p, err := ioutil.ReadAll(rc)
+				So(err, ShouldBeNil)
+				rc.Close()
+				entries[f.Name] = string(p)
+			}
+			So(entries, ShouldResemble, map[string]string{
+				"doc/":               "",
+				"doc/README.md":      string(readme),
+				"a/":                 "",
+				"a/b/":               "",
+				"a/b/README.txt":     string(readme),
+				"a/b/sub/":           "",
+				"a/b/sub/README.txt": string(readme),
+			})
+		})
+	})
+}
+
+func TestAddBytes(t *testing.T) {
+	Convey("Add entries from memory and io.Reader", t, func() {
+		name := filepath.Join(os.TempDir(), "testdata/TestAddBytes.zip")
+		z, err := Create(name)
+		So(err, ShouldBeNil)
+
+		So(z.AddBytes("dir/", nil, cae.EntryOptions{}), ShouldNotBeNil)
+		So(z.AddReader("foo", strings.NewReader("foo"), -1, cae.EntryOptions{}), ShouldNotBeNil)
+
+		mtime := time.Date(2020, 2, 2, 10, 20, 30, 0, time.UTC)
+		So(z.AddBytes("conf/app.ini", []byte("RUN_MODE = prod\n"), cae.EntryOptions{
+			Mode:    0600,
+			ModTime: mtime,
+		}), ShouldBeNil)
+		So(z.AddReader("MANIFEST", strings.NewReader("name: cae\n"), 10, cae.EntryOptions{}), ShouldBeNil)
+		So(z.List(), ShouldResemble, []string{"conf/", "conf/app.ini", "MANIFEST"})
+		So(z.Close(), ShouldBeNil)
+
+		zr, err := zip.OpenReader(name)
+		So(err, ShouldBeNil)
+		defer zr.Close()
+		So(len(zr.File), ShouldEqual, 3)
+
+		read := func(f *zip.File) string {
+			rc, err := f.Open()
+			So(err, ShouldBeNil)
+			defer rc.Close()
+			p, err := ioutil.ReadAll(rc)
+			So(err, ShouldBeNil)
+			return string(p)
+		}
+		So(read(zr.File[1]), ShouldEqual, "RUN_MODE = prod\n")
+		So(zr.File[1].Mode(), ShouldEqual, 0600)
+		So(zr.File[1].Modified.Unix(), ShouldEqual, mtime.Unix())
+		So(read(zr.File[2]), ShouldEqual, "name: cae\n")
+		So(zr.File[2].Mode(), ShouldEqual, 0644)
+
+		Convey("Flush with short content of reader", func() {
+			z, err := OpenFile(name, os.O_RDWR, 0)
+			So(err, ShouldBeNil)
+			defer z.Close()
+			So(z.AddReader("short", strings.NewReader("short"), 10, cae.EntryOptions{}), ShouldBeNil)
+			So(z.Flush(), ShouldEqual, io.ErrUnexpectedEOF)
+		})
+	})
+}
+
+func TestView(t *testing.T) {
+	Convey("View entries of archive as a file system", t, func() {
+		name := filepath.Join(os.TempDir(), "testdata/TestView.zip")
+		fw, err := os.Create(name)
+		So(err, ShouldBeNil)
+		zw := zip.NewWriter(fw)
+		for _, name := range []string{"a/b/c.txt", "d.txt", "a/e.txt"} {
+			w, err := zw.Create(name)
+			So(err, ShouldBeNil)
+			_, err = w.Write([]byte(name))
+			So(err, ShouldBeNil)
+		}
+		So(zw.Close(), ShouldBeNil)
+		So(fw.Close(), ShouldBeNil)
+
+		z, err := Open(name)
+		So(err, ShouldBeNil)
+		defer z.Close()
+
+		fsys := z.View()
+		So(fstest.TestFS(fsys, "a/b/c.txt", "d.txt", "a/e.txt"), ShouldBeNil)
+
+		// Upper level directories are synthesized.
+		fi, err := fsys.Stat("a/b")
+		So(err, ShouldBeNil)
+		So(fi.IsDir(), ShouldBeTrue)
+		des, err := fsys.ReadDir("a")
+		So(err, ShouldBeNil)
+		So(len(des), ShouldEqual, 2)
+		So(des[0].Name(), ShouldEqual, "b")
+		So(des[1].Name(), ShouldEqual, "e.txt")
+
+		p, err := fsys.ReadFile("a/b/c.txt")
+		So(err, ShouldBeNil)
+		So(string(p), ShouldEqual, "a/b/c.txt")
+		_, err = fsys.Open("404.txt")
+		So(errors.Is(err, fs.ErrNotExist), ShouldBeTrue)
+
+		matches, err := fs.Glob(fsys, "*/*.txt")
+		So(err, ShouldBeNil)
+		So(matches, ShouldResemble, []string{"a/e.txt"})
+
+		Convey("Seek in file", func() {
+			f, err := fsys.Open("d.txt")
+			So(err, ShouldBeNil)
+			defer f.Close()
+			rs := f.(io.ReadSeeker)
+
+			off, err := rs.Seek(0, io.SeekEnd)
+			So(err, ShouldBeNil)
+			So(off, ShouldEqual, 5)
+			_, err = rs.Seek(2, io.SeekStart)
+			So(err, ShouldBeNil)
+			p, err := ioutil.ReadAll(rs)
+			So(err, ShouldBeNil)
+			So(string(p), ShouldEqual, "txt")
+			_, err = rs.Seek(0, io.SeekStart)
+			So(err, ShouldBeNil)
+			p, err = ioutil.ReadAll(rs)
+			So(err, ShouldBeNil)
+			So(string(p), ShouldEqual, "d.txt")
+		})
+
+		Convey("View encrypted entries", func() {
+			z, err := Open("testdata/encrypted.zip")
+			So(err, ShouldBeNil)
+			defer z.Close()
+			z.Password = "cae-secret"
+			p, err := fs.ReadFile(z.View(), "secret.txt")
+			So(err, ShouldBeNil)
+			So(string(p), ShouldEqual, strings.Repeat("cae encrypted entry\n", 20))
+		})
+	})
+}
+
+func TestPackFS(t *testing.T) {
+	mtime := time.Date(2020, 2, 2, 10, 20, 30, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"README.md":          {Data: []byte("# cae\n"), Mode: 0644, ModTime: mtime},
+		"static/index.html":  {Data: []byte("<html></html>\n"), Mode: 0644, ModTime: mtime},
+		"static/css/app.css": {Data: []byte("body {}\n"), Mode: 0600, ModTime: mtime},
+		"static/.DS_Store":   {Data: []byte("junk")},
+	}
+
+	Convey("Pack files from fs.FS", t, func() {
+		var buf bytes.Buffer
+		So(PackFS(fsys, "static", &buf, PackOptions{}), ShouldBeNil)
+
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		So(err, ShouldBeNil)
+		names := make([]string, 0, len(zr.File))
+		for _, f := range zr.File {
+			names = append(names, f.Name)
+		}
+		So(names, ShouldResemble, []string{"css/", "css/app.css", "index.html"})
+		So(zr.File[1].Mode(), ShouldEqual, 0600)
+		So(zr.File[1].Modified.Unix(), ShouldEqual, mtime.Unix())
+
+		p, err := fs.ReadFile(zr, "css/app.css")
+		So(err, ShouldBeNil)
+		So(string(p), ShouldEqual, "body {}\n")
+	})
+
+	Convey("Add files from fs.FS to archive", t, func() {
+		name := filepath.Join(os.TempDir(), "testdata/TestAddFS.zip")
+		z, err := Create(name)
+		So(err, ShouldBeNil)
+		So(z.AddFS("assets", fsys, "."), ShouldBeNil)
+		So(z.List(), ShouldResemble, []string{
+			"assets/", "assets/README.md", "assets/static/", "assets/static/css/",
+			"assets/static/css/app.css", "assets/static/index.html",
+		})
+		So(z.Close(), ShouldBeNil)
+
+		z, err = Open(name)
+		So(err, ShouldBeNil)
+		defer z.Close()
+		So(fstest.TestFS(z.View(), "assets/README.md", "assets/static/css/app.css", "assets/static/index.html"), ShouldBeNil)
+		p, err := fs.ReadFile(z.View(), "assets/static/index.html")
+		So(err, ShouldBeNil)
+		So(string(p), ShouldEqual, "<html></html>\n")
+	})
+}
+
+func TestOpenEntry(t *testing.T) {
+	Convey("Open entries without extracting", t, func() {
+		name := filepath.Join(os.TempDir(), "testdata/TestOpenEntry.zip")
+		So(com.Copy("testdata/test.zip", name), ShouldBeNil)
+		z, err := OpenFile(name, os.O_RDWR, 0)
+		So(err, ShouldBeNil)
+		defer z.Close()
+
+		p, err := z.ReadFile("dir/bar")
+		So(err, ShouldBeNil)
+		So(string(p), ShouldEqual, "foo \r\n")
+
+		_, err = z.ReadFile("dir/")
+		So(err, ShouldNotBeNil)
+		_, err = z.ReadFile("404")
+		So(os.IsNotExist(err), ShouldBeTrue)
+
+		Convey("Open entries not flushed yet", func() {
+			readme, err := ioutil.ReadFile("testdata/README.txt")
+			So(err, ShouldBeNil)
+			So(z.AddFile("README.txt", "testdata/README.txt"), ShouldBeNil)
+			So(z.AddBytes("manifest.json", []byte("{}"), cae.EntryOptions{}), ShouldBeNil)
+			So(z.RenameEntry("hello", "world"), ShouldBeNil)
+
+			p, err := z.ReadFile("README.txt")
+			So(err, ShouldBeNil)
+			So(string(p), ShouldEqual, string(readme))
+
+			rc, err := z.OpenEntry("manifest.json")
+			So(err, ShouldBeNil)
+			p, err = ioutil.ReadAll(rc)
+			So(err, ShouldBeNil)
+			So(rc.Close(), ShouldBeNil)
+			So(string(p), ShouldEqual, "{}")
+
+			p, err = z.ReadFile("world")
+			So(err, ShouldBeNil)
+			So(string(p), ShouldEqual, "world \r\n")
+		})
+
+		Convey("Open entries added from io.Reader", func() {
+			r := strings.NewReader("--seekable")
+			_, err := r.Seek(2, io.SeekStart)
+			So(err, ShouldBeNil)
+			So(z.AddReader("seekable", r, 8, cae.EntryOptions{}), ShouldBeNil)
+			So(z.AddReader("stream", io.MultiReader(strings.NewReader("stream")), 6, cae.EntryOptions{}), ShouldBeNil)
+
+			for i := 0; i < 2; i++ {
+				p, err := z.ReadFile("seekable")
+				So(err, ShouldBeNil)
+				So(string(p), ShouldEqual, "seekable")
+			}
+			_, err = z.OpenEntry("stream")
+			So(err, ShouldNotBeNil)
+
+			So(z.Flush(), ShouldBeNil)
+			p, err := z.ReadFile("seekable")
+			So(err, ShouldBeNil)
+			So(string(p), ShouldEqual, "seekable")
+			p, err = z.ReadFile("stream")
+			So(err, ShouldBeNil)
+			So(string(p), ShouldEqual, "stream")
+		})
+	})
+}
+
+func TestListEntries(t *testing.T) {
+	Convey("List metadata of entries", t, func() {
+		name := filepath.Join(os.TempDir(), "testdata/TestListEntries.zip")
+		So(com.Copy("testdata/test.zip", name), ShouldBeNil)
+		z, err := OpenFile(name, os.O_RDWR, 0)
+		So(err, ShouldBeNil)
+		defer z.Close()
+
+		e, err := z.Stat("dir/bar")
+		So(err, ShouldBeNil)
+		So(e.Type, ShouldEqual, cae.TypeFile)
+		So(e.Size, ShouldEqual, 6)
+		So(e.CompressedSize, ShouldEqual, 8)
+		So(e.Method, ShouldEqual, "deflate")
+		So(e.CRC32, ShouldEqual, 0x7a7e9b9e)
+
+		e, err = z.Stat("dir/empty")
+		So(err, ShouldBeNil)
+		So(e.Name, ShouldEqual, "dir/empty/")
+		So(e.Type, ShouldEqual, cae.TypeDir)
+		So(e.Type.String(), ShouldEqual, "dir")
+
+		_, err = z.Stat("404")
+		So(os.IsNotExist(err), ShouldBeTrue)
+
+		entries, err := z.ListEntries(func(e cae.Entry) bool {
+			return e.Type == cae.TypeDir
+		})
+		So(err, ShouldBeNil)
+		So(len(entries), ShouldEqual, 2)
+		So(entries[0].Name, ShouldEqual, "dir/")
+		So(entries[1].Name, ShouldEqual, "dir/empty/")
+
+		Convey("List entries not flushed yet", func() {
+			So(z.AddBytes("manifest.json", []byte("{}"), cae.EntryOptions{Mode: 0600}), ShouldBeNil)
+			e, err := z.Stat("manifest.json")
+			So(err, ShouldBeNil)
+			So(e.Size, ShouldEqual, 2)
+			So(e.CompressedSize, ShouldEqual, -1)
+			So(e.Mode, ShouldEqual, 0600)
+
+			entries, err := z.ListEntries(nil)
+			So(err, ShouldBeNil)
+			So(len(entries), ShouldEqual, 6)
+		})
+	})
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	Convey("List symbolic links", t, func() {
+		src := path.Join(os.TempDir(), "testdata/TestListEntries")
+		name := path.Join(os.TempDir(), "testdata/TestListEntriesLink.zip")
+		os.RemoveAll(src)
+		So(os.MkdirAll(src, os.ModePerm), ShouldBeNil)
+		So(os.Symlink("README.txt", path.Join(src, "link")), ShouldBeNil)
+		So(PackTo(src, name), ShouldBeNil)
+
+		z, err := Open(name)
+		So(err, ShouldBeNil)
+		defer z.Close()
+		e, err := z.Stat("link")
+		So(err, ShouldBeNil)
+		So(e.Type, ShouldEqual, cae.TypeSymlink)
+		So(e.LinkTarget, ShouldEqual, "README.txt")
+	})
+}
+
+func TestMatcher(t *testing.T) {
+	Convey("Select entries by matchers", t, func() {
+		name := filepath.Join(os.TempDir(), "testdata/TestMatcher.zip")
+		So(com.Copy("testdata/test.zip", name), ShouldBeNil)
+		z, err := OpenFile(name, os.O_RDWR, 0)
+		So(err, ShouldBeNil)
+		defer z.Close()
+
+		m, err := cae.Glob("**/bar", "hello")
+		So(err, ShouldBeNil)
+		So(z.ListMatch(m), ShouldResemble, []string{"dir/bar", "hello"})
+
+		Convey("Extract entries by matcher", func() {
+			dest := filepath.Join(os.TempDir(), "testdata/TestMatcher")
+			os.RemoveAll(dest)
+			include, err := cae.Glob("dir/**")
+			So(err, ShouldBeNil)
+			exclude, err := cae.Gitignore("bar")
+			So(err, ShouldBeNil)
+			z.ExtractMatcher = cae.Select(include, exclude)
+			So(z.ExtractTo(dest), ShouldBeNil)
+
+			list, err := com.StatDir(dest, true)
+			So(err, ShouldBeNil)
+			So(list, ShouldResemble, []string{"dir/", "dir/empty/"})
+		})
+	})
+
+	Convey("Pack files by matcher", t, func() {
+		src := filepath.Join(os.TempDir(), "testdata/TestMatcherSrc")
+		os.RemoveAll(src)
+		So(os.MkdirAll(filepath.Join(src, "node_modules/pkg"), os.ModePerm), ShouldBeNil)
+		So(os.MkdirAll(filepath.Join(src, "cmd"), os.ModePerm), ShouldBeNil)
+		for _, name := range []string{"main.go", "cmd/main.go", "node_modules/pkg/index.js", "README.md"} {
+			So(ioutil.WriteFile(filepath.Join(src, name), []byte(name), 0644), ShouldBeNil)
+		}
+		exclude, err := cae.Gitignore("node_modules/", "*.md")
+		So(err, ShouldBeNil)
+		m := cae.Select(nil, exclude)
+
+		name := filepath.Join(os.TempDir(), "testdata/TestMatcherPack.zip")
+		So(PackToWithOptions(src, name, PackOptions{PackMatcher: m}), ShouldBeNil)
+		z, err := Open(name)
+		So(err, ShouldBeNil)
+		defer z.Close()
+		list := z.List()
+		sort.Strings(list)
+		So(list, ShouldResemble, []string{"cmd/", "cmd/main.go", "main.go"})
+
+		Convey("Add directory by matcher", func() {
+			z, err := Create(filepath.Join(os.TempDir(), "testdata/TestMatcherAdd.zip"))
+			So(err, ShouldBeNil)
+			defer z.Close()
+			z.PackMatcher = m
+			So(z.AddDir("src", src), ShouldBeNil)
+			list := z.List()
+			sort.Strings(list)
+			So(list, ShouldResemble, []string{"src/", "src/cmd/", "src/cmd/main.go", "src/main.go"})
+		})
+	})
+}